@@ -0,0 +1,238 @@
+package differ
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/heeus/gocov/shared"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/cover"
+)
+
+// Range is an inclusive range of changed lines
+type Range struct {
+	Start int
+	End   int
+}
+
+// Line identifies a single source line
+type Line struct {
+	File string
+	Line int
+}
+
+// Result holds the coverage of the changed lines
+type Result struct {
+	Statements int
+	Covered    int
+	Uncovered  []Line
+}
+
+// Percent returns the share of changed statements that are covered
+func (r *Result) Percent() float64 {
+	if r.Statements == 0 {
+		return 100
+	}
+	return float64(r.Covered) * 100 / float64(r.Statements)
+}
+
+// New creates a new Differ with the provided setup
+func New(setup *shared.Setup) *Differ {
+	return &Differ{
+		setup: setup,
+	}
+}
+
+// Differ finds the lines changed since a git base ref and intersects them
+// with coverage results
+type Differ struct {
+	setup   *shared.Setup
+	Changes map[string][]Range
+}
+
+// Load runs 'git diff' against the merge base of the base ref and HEAD, so
+// that changes made on the base ref since are not counted, and stores the
+// changed line ranges keyed by the full filepath. Untracked files that are
+// not ignored are new, so all of their lines count as changed.
+func (d *Differ) Load() error {
+	wd, err := d.setup.Env.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "Error getting working dir")
+	}
+	out, err := d.git(wd, "diff", "--merge-base", "--unified=0", "--no-color", "--no-ext-diff", "--no-prefix", "--relative", d.setup.Base, "--", ".")
+	if err != nil {
+		return err
+	}
+	changes, err := Parse(strings.NewReader(out))
+	if err != nil {
+		return err
+	}
+	out, err = d.git(wd, "ls-files", "--others", "--exclude-standard", "--", ".")
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(out, "\n") {
+		if name == "" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(wd, filepath.FromSlash(name)))
+		if err != nil {
+			return errors.Wrapf(err, "Error reading untracked file %s", name)
+		}
+		lines := bytes.Count(b, []byte("\n"))
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			lines++
+		}
+		if lines > 0 {
+			changes[name] = []Range{{Start: 1, End: lines}}
+		}
+	}
+	d.Changes = make(map[string][]Range, len(changes))
+	for name, ranges := range changes {
+		d.Changes[filepath.Join(wd, filepath.FromSlash(name))] = ranges
+	}
+	return nil
+}
+
+// git runs a git command in dir and returns its output
+func (d *Differ) git(dir string, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	exe := exec.Command("git", args...)
+	exe.Dir = dir
+	exe.Env = d.setup.Env.Environ()
+	exe.Stdout = stdout
+	exe.Stderr = stderr
+	if err := exe.Run(); err != nil {
+		return "", &shared.ToolError{Err: errors.Wrapf(err, "Error executing git %s \nOutput:[\n%s]\n", args[0], stderr.String())}
+	}
+	return stdout.String(), nil
+}
+
+// Compare intersects the changed lines with the coverage profiles. Only
+// blocks that contain at least one changed line are taken into account.
+func (d *Differ) Compare(profiles []*cover.Profile) (*Result, error) {
+	r := &Result{}
+	for _, p := range profiles {
+		fpath, err := d.setup.Paths.FilePath(p.FileName)
+		if err != nil {
+			return nil, err
+		}
+		ranges, ok := d.Changes[fpath]
+		if !ok {
+			continue
+		}
+		uncovered := map[int]bool{}
+		for _, b := range p.Blocks {
+			lines := changedLines(ranges, b.StartLine, b.EndLine)
+			if len(lines) == 0 {
+				continue
+			}
+			r.Statements += b.NumStmt
+			if b.Count > 0 {
+				r.Covered += b.NumStmt
+				continue
+			}
+			for _, line := range lines {
+				uncovered[line] = true
+			}
+		}
+		for line := range uncovered {
+			r.Uncovered = append(r.Uncovered, Line{File: fpath, Line: line})
+		}
+	}
+	sort.Slice(r.Uncovered, func(i, j int) bool {
+		if r.Uncovered[i].File != r.Uncovered[j].File {
+			return r.Uncovered[i].File < r.Uncovered[j].File
+		}
+		return r.Uncovered[i].Line < r.Uncovered[j].Line
+	})
+	return r, nil
+}
+
+func changedLines(ranges []Range, start, end int) []int {
+	var lines []int
+	for _, rg := range ranges {
+		if rg.End < start || rg.Start > end {
+			continue
+		}
+		from, to := rg.Start, rg.End
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		for line := from; line <= to; line++ {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parse reads the output of 'git diff --unified=0 --no-prefix' and returns the
+// changed line ranges in the new version of each file, keyed by filename.
+// Deleted files and hunks that only remove lines are skipped.
+func Parse(r io.Reader) (map[string][]Range, error) {
+	changes := make(map[string][]Range)
+	var current, prev string
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ ") && strings.HasPrefix(prev, "--- "):
+			current = strings.TrimPrefix(line, "+++ ")
+			if current == "/dev/null" {
+				current = ""
+			}
+		case strings.HasPrefix(line, "@@ ") && current != "":
+			rg, ok, err := parseHunk(line)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				changes[current] = append(changes[current], rg)
+			}
+		}
+		prev = line
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "Error reading git diff output")
+	}
+	return changes, nil
+}
+
+// parseHunk parses a hunk header like "@@ -10,2 +12,3 @@ func foo() {"
+func parseHunk(line string) (Range, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return Range{}, false, errors.Errorf("Malformed hunk header: %s", line)
+	}
+	pos := strings.TrimPrefix(fields[2], "+")
+	count := 1
+	if i := strings.Index(pos, ","); i >= 0 {
+		c, err := strconv.Atoi(pos[i+1:])
+		if err != nil {
+			return Range{}, false, errors.Wrapf(err, "Malformed hunk header: %s", line)
+		}
+		count = c
+		pos = pos[:i]
+	}
+	start, err := strconv.Atoi(pos)
+	if err != nil {
+		return Range{}, false, errors.Wrapf(err, "Malformed hunk header: %s", line)
+	}
+	if count == 0 {
+		// lines were only removed
+		return Range{}, false, nil
+	}
+	return Range{Start: start, End: start + count - 1}, true, nil
+}
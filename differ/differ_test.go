@@ -0,0 +1,189 @@
+package differ_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/heeus/gocov/differ"
+	"github.com/heeus/gocov/shared"
+	"github.com/heeus/gocov/shared/builder"
+	"github.com/heeus/gocov/shared/vos"
+	"golang.org/x/tools/cover"
+)
+
+func TestParse(t *testing.T) {
+	out := `diff --git a.go a.go
+index 3b18e51..a3c2b1f 100644
+--- a.go
++++ a.go
+@@ -3,0 +4,2 @@ func Foo(i int) int {
++	i++
++	i++
+@@ -10 +12 @@ func Bar(i int) int {
+-	return i
++++ return i
+@@ -20,3 +22,0 @@ func Baz() {
+-	a()
+-	b()
+-	c()
+diff --git b/b.go b/b.go
+deleted file mode 100644
+index 3b18e51..0000000
+--- b/b.go
++++ /dev/null
+@@ -1,3 +0,0 @@
+-package b
+-
+-func B() {}
+diff --git c/c.go c/c.go
+new file mode 100644
+index 0000000..3b18e51
+--- /dev/null
++++ c/c.go
+@@ -0,0 +1,3 @@
++package c
++
++func C() {}
+`
+	changes, err := differ.Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("Error parsing diff: %s", err)
+	}
+	expected := map[string][]differ.Range{
+		"a.go":   {{Start: 4, End: 5}, {Start: 12, End: 12}},
+		"c/c.go": {{Start: 1, End: 3}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Error parsing diff - got:\n%#v\nexpected:\n%#v\n", changes, expected)
+	}
+
+	if _, err := differ.Parse(strings.NewReader("--- a.go\n+++ a.go\n@@ -1 +x @@\n")); err == nil {
+		t.Fatal("Error parsing diff - should get error for malformed hunk, got nil")
+	}
+}
+
+func TestResult_Percent(t *testing.T) {
+	r := &differ.Result{}
+	if r.Percent() != 100 {
+		t.Fatalf("Error in Percent - got %v, expected 100", r.Percent())
+	}
+	r = &differ.Result{Statements: 4, Covered: 3}
+	if r.Percent() != 75 {
+		t.Fatalf("Error in Percent - got %v, expected 75", r.Percent())
+	}
+}
+
+func TestDiffer_Compare(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %s", err)
+	}
+	defer b.Cleanup()
+
+	_, pdir, err := b.Package("a", map[string]string{
+		"a.go": "package a\n",
+		"b.go": "package a\n",
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %s", err)
+	}
+	if err := env.Setwd(pdir); err != nil {
+		t.Fatalf("Error in Setwd: %s", err)
+	}
+
+	d := differ.New(&shared.Setup{Env: env, Paths: shared.NewCache(env)})
+	d.Changes = map[string][]differ.Range{
+		filepath.Join(pdir, "a.go"): {{Start: 4, End: 5}, {Start: 8, End: 8}},
+	}
+	profiles := []*cover.Profile{
+		{
+			FileName: "ns/a/a.go",
+			Blocks: []cover.ProfileBlock{
+				// partly changed and uncovered: only the changed lines count
+				{StartLine: 3, EndLine: 4, NumStmt: 2, Count: 0},
+				// changed and covered
+				{StartLine: 7, EndLine: 9, NumStmt: 1, Count: 1},
+				// not changed
+				{StartLine: 11, EndLine: 12, NumStmt: 3, Count: 0},
+			},
+		},
+		{
+			// not in the diff
+			FileName: "ns/a/b.go",
+			Blocks:   []cover.ProfileBlock{{StartLine: 1, EndLine: 9, NumStmt: 4, Count: 0}},
+		},
+	}
+	r, err := d.Compare(profiles)
+	if err != nil {
+		t.Fatalf("Error comparing: %s", err)
+	}
+	expected := &differ.Result{
+		Statements: 3,
+		Covered:    1,
+		Uncovered:  []differ.Line{{File: filepath.Join(pdir, "a.go"), Line: 4}},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("Error in Compare - got:\n%#v\nexpected:\n%#v\n", r, expected)
+	}
+}
+
+func TestDiffer_Load(t *testing.T) {
+	dir, err := os.MkdirTemp("", "differ")
+	if err != nil {
+		t.Fatalf("Error creating temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatalf("Error in EvalSymlinks: %s", err)
+	}
+	write := func(name, contents string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Error writing %s: %s", name, err)
+		}
+	}
+	git := func(args ...string) {
+		exe := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		exe.Dir = dir
+		if out, err := exe.CombinedOutput(); err != nil {
+			t.Fatalf("Error executing git %v: %s\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	write("a.go", "package a\n\nfunc A() {}\n")
+	write("b.go", "package a\n\nfunc B() {}\n")
+	write(".gitignore", "ignored.go\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	git("checkout", "-q", "-b", "feature")
+	write("a.go", "package a\n\nfunc A() {}\n\nfunc A2() {}\n")
+	git("commit", "-q", "-am", "feature")
+	// main moves on after the feature branch was created
+	git("checkout", "-q", "main")
+	write("b.go", "package a\n\nfunc B() {}\n\nfunc B2() {}\n")
+	git("commit", "-q", "-am", "main")
+	git("checkout", "-q", "feature")
+	// new files are included unless they are ignored
+	write("c.go", "package a\n\nfunc C() {}")
+	write("ignored.go", "package a\n")
+
+	env := vos.Mock()
+	if err := env.Setwd(dir); err != nil {
+		t.Fatalf("Error in Setwd: %s", err)
+	}
+	d := differ.New(&shared.Setup{Env: env, Base: "main"})
+	if err := d.Load(); err != nil {
+		t.Fatalf("Error loading diff: %+v", err)
+	}
+	expected := map[string][]differ.Range{
+		filepath.Join(dir, "a.go"): {{Start: 4, End: 5}},
+		filepath.Join(dir, "c.go"): {{Start: 1, End: 3}},
+	}
+	if !reflect.DeepEqual(d.Changes, expected) {
+		t.Fatalf("Error in changes - got:\n%#v\nexpected:\n%#v\n", d.Changes, expected)
+	}
+}
@@ -6,11 +6,11 @@ require (
 	github.com/dave/astrid v0.0.0-20170323122508-8c2895878b14
 	github.com/dave/brenda v1.1.0
 	github.com/pkg/errors v0.9.1
-	golang.org/x/mod v0.7.0
 	golang.org/x/tools v0.5.0
 )
 
 require (
 	github.com/dave/patsy v0.0.0-20210517141501-957256f50cba // indirect
+	golang.org/x/mod v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 )
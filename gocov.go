@@ -1,27 +1,25 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
-	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/cover"
 
+	"github.com/heeus/gocov/differ"
+	"github.com/heeus/gocov/report"
 	"github.com/heeus/gocov/scanner"
 	"github.com/heeus/gocov/shared"
 	"github.com/heeus/gocov/shared/vos"
 	"github.com/heeus/gocov/tester"
 )
 
-const (
-	gomod = "go.mod"
-)
-
 func main() {
 	// notest
 	env := vos.Os()
@@ -31,119 +29,202 @@ func main() {
 	var shortFlag bool
 	var timeoutFlag string
 	var outputFlag string
+	var coberturaFlag string
+	var lcovFlag string
+	var lcovBranchesFlag bool
+	var sarifFlag string
+	var junitFlag string
+	var baselineFlag string
+	var linkBaseFlag string
+	var funcFlag bool
+	var exclusionsFlag bool
+	var rangesFlag bool
+	var contextFlag int
 	var loadFlag string
-
+	var baseFlag string
+	var thresholdFlag float64
+	var intervalFlag time.Duration
+	var recurseFlag bool
+	var parallelFlag int
+	var singleFlag bool
+	var retriesFlag int
+	var keepGoingFlag bool
+	var runFlag string
+
+	fs := flag.CommandLine
 	argsFlag := new(argsValue)
-	flag.Var(argsFlag, "t", "Argument to pass to the 'go test' command. Can be used more than once.")
-
-	fs := flag.NewFlagSet("gocov", flag.ContinueOnError)
+	buildFlag := new(argsValue)
+	formatFlag := &choiceValue{name: "format", choices: formats, value: shared.FormatText}
+	sortFlag := &choiceValue{name: "sort order", choices: report.SortOrders, value: report.SortFile}
+	pathsFlag := &choiceValue{name: "path style", choices: shared.PathStyles, value: shared.PathsCwd}
+	fs.Var(argsFlag, "t", "Argument to pass to the 'go test' command. Can be used more than once.")
 	fs.BoolVar(&enforceFlag, "e", false, "Enforce 100% code coverage")
 	fs.BoolVar(&verboseFlag, "v", false, "Verbose output")
+	fs.IntVar(&parallelFlag, "p", 1, "Number of packages to test in parallel")
+	fs.BoolVar(&singleFlag, "single", false, "Run 'go test' once for all packages instead of once per dir (go 1.20 and later)")
+	fs.IntVar(&retriesFlag, "retries", 0, "Rerun failed tests up to N times and report the tests that pass on a retry as flaky")
+	fs.BoolVar(&keepGoingFlag, "keep-going", false, "Test all packages when tests fail, report the coverage of the packages that passed and exit with the failures at the end")
 	fs.BoolVar(&shortFlag, "short", false, "Pass the short flag to the go test command")
 	fs.StringVar(&timeoutFlag, "timeout", "", "Pass the timeout flag to the go test command")
 	fs.StringVar(&outputFlag, "o", "", "Override coverage file location")
-	fs.StringVar(&loadFlag, "l", "", "Load coverage file(s) instead of running 'go test'")
+	fs.StringVar(&coberturaFlag, "cobertura", "", "Also write a Cobertura XML report to this file")
+	fs.StringVar(&lcovFlag, "lcov", "", "Also write an LCOV tracefile to this file")
+	fs.BoolVar(&lcovBranchesFlag, "lcov-branches", false, "Add branch records to the LCOV tracefile")
+	fs.StringVar(&sarifFlag, "sarif", "", "Also write a SARIF log of uncovered code and outdated exclusions to this file")
+	fs.StringVar(&junitFlag, "junit", "", "Also write the test results as JUnit XML to this file")
+	fs.BoolVar(&funcFlag, "func", false, "Show the coverage of every function")
+	fs.BoolVar(&exclusionsFlag, "exclusions", false, "Show raw coverage and excluded statements of every package")
+	fs.BoolVar(&rangesFlag, "ranges", false, "Show uncovered code as merged file:start-end ranges")
+	fs.IntVar(&contextFlag, "context", 0, "Show the source of uncovered ranges with this many lines of context")
+	fs.Var(sortFlag, "sort", "Order of uncovered ranges: "+strings.Join(report.SortOrders, ", "))
+	fs.Var(pathsFlag, "paths", "Show file paths relative to the working dir, the module root or absolute: "+strings.Join(shared.PathStyles, ", "))
+	fs.BoolVar(&recurseFlag, "recurse-modules", false, "Also test nested modules and merge their coverage")
+	fs.StringVar(&loadFlag, "l", "", "Load coverage file(s), or GOCOVERDIR dir(s) of binaries built with -cover, instead of running 'go test'. If packages are given too, their tests are run and the coverage is merged")
 	fs.BoolVar(&verboseFlag, "notest", false, "notest")
 	fs.BoolVar(&verboseFlag, "notestdept", false, "notest")
+	fs.Var(buildFlag, "build", "Package of a binary to build with coverage in 'gocov integration'. Can be used more than once.")
+	fs.StringVar(&runFlag, "run", "", "Script that runs the binaries in 'gocov integration'")
+	fs.StringVar(&baseFlag, "base", "HEAD", "Git ref whose merge base with HEAD is compared against in 'gocov diff'")
+	fs.Float64Var(&thresholdFlag, "threshold", 0, "Minimum coverage percentage of changed lines in 'gocov diff'")
+	fs.DurationVar(&intervalFlag, "interval", time.Second, "Polling interval in 'gocov watch'")
+	fs.StringVar(&baselineFlag, "baseline", "", "Coverage profile to compare packages against in '-format markdown'")
+	fs.StringVar(&linkBaseFlag, "link-base", "", "URL prefix for file links in '-format markdown', e.g. https://github.com/org/repo/blob/main/")
+	fs.Var(formatFlag, "format", "Output format: "+strings.Join(formats, ", "))
 
 	start := 1
 
 	notestParam := false
 	notestdeptParam := false
+	diffParam := false
+	watchParam := false
+	htmlParam := false
+	integrationParam := false
 	if len(os.Args) > 1 {
 		notestParam = os.Args[1] == "notest"
 		notestdeptParam = os.Args[1] == "notestdept"
-		if notestParam || notestdeptParam {
+		diffParam = os.Args[1] == "diff"
+		watchParam = os.Args[1] == "watch"
+		htmlParam = os.Args[1] == "html"
+		integrationParam = os.Args[1] == "integration"
+		if notestParam || notestdeptParam || diffParam || watchParam || htmlParam || integrationParam {
 			start = 2
 		}
 	}
 
-	if start == 2 {
-		err := flag.CommandLine.Parse(os.Args[start:])
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-	} else {
-		flag.Parse()
-	}
-	err := fs.Parse(os.Args[start:])
-	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
-	}
+	// flag.CommandLine exits with ExitUsage on errors
+	fs.Parse(os.Args[start:])
 	setup := &shared.Setup{
-		Env:        env,
-		Paths:      shared.NewCache(env),
-		Enforce:    enforceFlag,
-		Verbose:    verboseFlag,
-		Short:      shortFlag,
-		Timeout:    timeoutFlag,
-		Output:     outputFlag,
-		Notest:     notestParam,
-		Notestdept: notestdeptParam,
-		TestArgs:   argsFlag.args,
-		Load:       loadFlag,
+		Env:            env,
+		Paths:          shared.NewCache(env),
+		Enforce:        enforceFlag,
+		Verbose:        verboseFlag,
+		Short:          shortFlag,
+		Timeout:        timeoutFlag,
+		Output:         outputFlag,
+		Cobertura:      coberturaFlag,
+		LCOV:           lcovFlag,
+		LCOVBranches:   lcovBranchesFlag,
+		SARIF:          sarifFlag,
+		JUnit:          junitFlag,
+		Baseline:       baselineFlag,
+		LinkBase:       linkBaseFlag,
+		Func:           funcFlag,
+		Exclusions:     exclusionsFlag,
+		Ranges:         rangesFlag,
+		Context:        contextFlag,
+		Sort:           sortFlag.value,
+		PathStyle:      pathsFlag.value,
+		RecurseModules: recurseFlag,
+		Parallel:       parallelFlag,
+		Single:         singleFlag,
+		Retries:        retriesFlag,
+		KeepGoing:      keepGoingFlag,
+		Notest:         notestParam,
+		Notestdept:     notestdeptParam,
+		Diff:           diffParam,
+		Base:           baseFlag,
+		Threshold:      thresholdFlag,
+		Format:         formatFlag.value,
+		TestArgs:       argsFlag.args,
+		Load:           loadFlag,
+		Args:           fs.Args(),
+	}
+	// context and sort only apply to ranges
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "context" || f.Name == "sort" {
+			setup.Ranges = true
+		}
+	})
+	if recurseFlag && !isFlagSet(fs, "paths") {
+		// show files as a run inside of their module would, even though files
+		// of different modules can have the same path then
+		setup.PathStyle = shared.PathsModule
+	}
+	if htmlParam {
+		// in 'gocov html' the -o flag sets the report file
+		setup.HTML = "coverage.html"
+		if outputFlag != "" {
+			setup.HTML = outputFlag
+		}
+		setup.Output = ""
 	}
 
-	if err := Run(setup); err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+	if integrationParam {
+		if runFlag == "" {
+			exit(setup, &shared.UsageError{Err: errors.New("Error - 'gocov integration' needs a script to run with -run")})
+		}
+		if len(buildFlag.args) == 0 {
+			exit(setup, &shared.UsageError{Err: errors.New("Error - 'gocov integration' needs packages to build with -build")})
+		}
+		setup.Builds = buildFlag.args
+		setup.Script = runFlag
 	}
 
-	out := tester.CoverageFileName
-	outun := tester.UncoverageFileName
+	if watchParam {
+		exit(setup, Watch(setup, intervalFlag))
+	}
 
-	if setup.Notest || setup.Notestdept {
-		printNotCoverLinks(setup, outun, false)
-	} else {
-		printNotCoverLinks(setup, out, true)
-		printTotalCoverage(setup, out)
+	err := Run(setup)
+	os.Remove(tester.CoverageFileName)
+	os.Remove(tester.UncoverageFileName)
+	if err != nil {
+		exit(setup, err)
 	}
+}
 
-	os.Remove(out)
-	os.Remove(outun)
+// exit prints the error and exits with the matching exit code. When a
+// machine readable format is selected the error goes to stderr, so that
+// stdout stays parseable.
+func exit(setup *shared.Setup, err error) {
+	// notest
+	out := setup.Env.Stdout()
+	if !setup.TextOutput() {
+		out = setup.Env.Stderr()
+	}
+	fmt.Fprintln(out, err.Error())
+	os.Exit(shared.ExitCode(err))
 }
 
+// printNotCoverLinks prints file:line of the blocks with no count in the
+// profile fn, e.g. the lines not covered by tests or the lines with a
+// notest instruction
 func printNotCoverLinks(setup *shared.Setup, fn string, covered bool) {
-
-	by, err := os.ReadFile(fn)
+	profiles, err := cover.ParseProfiles(fn)
 	if err != nil {
 		return
 	}
-	lines := strings.Split(string(by), "\n")
-	var posfrom string
-	var filename string
-	var pritnstsr []string
-	for i, str := range lines {
-
-		if i > 0 {
-			strline := strings.Split(str, " ")
-			if len(strline) == 3 {
-				posline := strings.Split(str, ",")
-				if len(posline) == 2 {
-					poslinefrom := strings.Split(posline[0], ":")
-					posto := posline[1]
-					if badStatus(posto) {
-						if len(poslinefrom) == 2 {
-							posfrom = poslinefrom[1]
-							fullfilename := poslinefrom[0]
-							if setup.Notest || setup.Notestdept {
-								filename = getFullNameFromPath(fullfilename)
-							} else {
-								filename = getFullNameFromCover(fullfilename)
-							}
-						}
-
-						if len(posfrom) > 0 && len(filename) > 0 {
-							linstrs := strings.Split(posfrom, ".")
-							if len(linstrs) > 0 {
-								posfrom = linstrs[0]
-							}
-							pritnstsr = append(pritnstsr, filename+":"+posfrom)
-						}
-					}
-				}
+	var links []string
+	for _, p := range profiles {
+		fpath := p.FileName
+		if !filepath.IsAbs(fpath) {
+			if fpath, err = setup.Paths.FilePath(p.FileName); err != nil {
+				continue
+			}
+		}
+		name := setup.DisplayPath(fpath)
+		for _, b := range p.Blocks {
+			if b.Count == 0 {
+				links = append(links, fmt.Sprintf("%s:%d", name, b.StartLine))
 			}
 		}
 	}
@@ -164,215 +245,425 @@ func printNotCoverLinks(setup *shared.Setup, fn string, covered bool) {
 			"The following lines have instruction " + flag + ":\t\n" +
 			"-------------------------------------------------"
 	}
-	if len(pritnstsr) > 0 {
-		fmt.Println(s)
-		for _, str := range pritnstsr {
-			fmt.Println(str)
+	if len(links) > 0 {
+		out := setup.Env.Stdout()
+		fmt.Fprintln(out, s)
+		for _, str := range links {
+			fmt.Fprintln(out, str)
 		}
 	}
 }
 
-func badStatus(statusline string) bool {
-	if len(statusline) < 3 {
-		return true
+// printCoverage prints the uncovered lines and the total coverage, or the
+// coverage of every function if setup.Func is set, and the exclusions of
+// every package if setup.Exclusions is set
+func printCoverage(setup *shared.Setup, s *scanner.CodeMap, t *tester.Tester) error {
+	if !setup.Ranges {
+		printNotCoverLinks(setup, tester.CoverageFileName, true)
 	}
-	strs := strings.Split(statusline, " ")
-	if len(strs) < 3 {
-		return true
+	if len(t.Results) == 0 {
+		return nil
 	}
-	return strs[2] == "0"
-}
-
-func getFullNameFromPath(fullfilename string) string {
-	mydir, _ := os.Getwd()
-	start := len(mydir) + 1
-
-	return "./" + substr(fullfilename, start, len(fullfilename)-start)
+	r, err := newReport(setup, s, t)
+	if err != nil {
+		return err
+	}
+	out := setup.Env.Stdout()
+	if setup.Ranges {
+		if err := r.WriteUncovered(out, setup.Context, setup.Sort); err != nil {
+			return err
+		}
+	}
+	if setup.Func {
+		if err := r.WriteFuncs(out); err != nil {
+			return err
+		}
+	} else {
+		if err := r.WriteModules(out); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "coverage: %.1f%% of statements", r.Total.Percent)
+		if r.Total.Excluded > 0 {
+			fmt.Fprintf(out, " (%.1f%% before excluding %s)", r.Total.RawPercent, r.Total.ExcludedBy())
+		}
+		fmt.Fprintln(out)
+	}
+	if err := r.WriteFlaky(out); err != nil {
+		return err
+	}
+	if setup.Exclusions {
+		return r.WriteExclusions(out)
+	}
+	return nil
 }
 
-func customVersionFixer(modPath, version string) (string, error) {
-
-	fmt.Println(version)
-	// If the version string matches the pattern, extract and return the major and minor parts
-	if version == "1.21.4" {
-		return "1.21", nil
+// Run initiates the command with the provided setup. The returned error can
+// be mapped to the documented exit code with shared.ExitCode.
+func Run(setup *shared.Setup) error {
+	s := scanner.New(setup)
+	if setup.RecurseModules {
+		if err := scanModules(setup, s); err != nil {
+			return err
+		}
+	} else if err := scan(setup, s, setup.Args); err != nil {
+		return err
 	}
-	// Return the original version if no change is needed
-	return version, nil
 
-}
-func getFullNameFromCover(fullfilename string) string {
+	t := tester.New(setup)
 
-	// Search first go.mod in current and parent folders
-	goModfile, path := findGoMod()
-	fb, err := os.ReadFile(goModfile)
-	if err != nil {
-		return ""
+	// with -keep-going a test failure is returned after the reports are written
+	var testErr error
+	if !(setup.Notest || setup.Notestdept) {
+		// with -l the tests are only run if packages are given too, and the
+		// loaded profiles are merged with their coverage
+		if setup.Load == "" || len(setup.Args) > 0 {
+			err := t.Test()
+			if setup.JUnit != "" {
+				// test results are most useful when tests fail
+				if err := t.SaveJUnit(); err != nil {
+					return errors.Wrapf(err, "SaveJUnit")
+				}
+			}
+			if err != nil && setup.KeepGoing {
+				testErr = errors.Wrapf(err, "Test")
+			} else if err != nil {
+				if setup.Format == shared.FormatJSON {
+					// report the failures in the document too, with the
+					// same exclusions as in a passing run
+					if err := t.ProcessExcludes(s.Excludes); err != nil {
+						return errors.Wrapf(err, "ProcessExcludes")
+					}
+					if err := writeReport(setup, s, t); err != nil {
+						return errors.Wrapf(err, "Report")
+					}
+				}
+				return errors.Wrapf(err, "Test")
+			}
+		}
+		if setup.Load != "" {
+			if err := t.Load(); err != nil {
+				return errors.Wrapf(err, "Load")
+			}
+		}
+		if setup.Script != "" {
+			if err := t.Integration(); err != nil {
+				return errors.Wrapf(err, "Integration")
+			}
+		}
 	}
 
-	//	fmt.Println("goModfile", goModfile)
-	f, err := modfile.Parse(goModfile, fb, customVersionFixer)
-	if err != nil {
-		fmt.Println(err.Error())
-		return ""
+	if err := t.ProcessExcludes(s.Excludes); err != nil {
+		return errors.Wrapf(err, "ProcessExcludes")
 	}
 
-	pos := strings.Index(fullfilename, f.Module.Mod.Path)
-	if pos < 0 {
-		return ""
+	if !(setup.Notest || setup.Notestdept) {
+		if err := t.Save(); err != nil {
+			return errors.Wrapf(err, "Save")
+		}
 	}
 
-	cutpath := f.Module.Mod.Path + strings.ReplaceAll(path, "\\", "/")
-	testPath := fullfilename
-	curdir, _ := os.Getwd()
-	curdir = filepath.Base(curdir)
-
-	addPath := ""
-	for cutpath != testPath {
-		partpath := filepath.Base(testPath)
-		needbreak := false
-		if partpath != curdir {
-			if addPath == "" {
-				addPath = partpath
-			} else {
-				addPath = partpath + "/" + addPath
-			}
-		} else {
-			needbreak = true
+	if setup.Notest {
+		if err := t.SaveUn(shared.Notest); err != nil {
+			return errors.Wrapf(err, "SaveUn")
 		}
-		testPath = substr(testPath, 0, len(testPath)-len(partpath)-1)
-		if needbreak {
-			break
+	}
+	if setup.Notestdept {
+		if err := t.SaveUn(shared.Notestdept); err != nil {
+			return errors.Wrapf(err, "SaveUn")
 		}
 	}
-	return "./" + addPath
-}
-
-func findGoMod() (goModPath string, addPath string) {
-	addPath = ""
-	root, _ := os.Getwd()
-	pattern := gomod
-	for {
-		matches, err := filepath.Glob(root + "/" + pattern)
 
-		if err != nil {
-			fmt.Println(err)
+	if setup.Cobertura != "" {
+		if err := t.SaveCobertura(); err != nil {
+			return errors.Wrapf(err, "SaveCobertura")
 		}
+	}
 
-		if len(matches) != 0 {
-			return matches[0], addPath
+	if setup.LCOV != "" {
+		if err := t.SaveLCOV(s.Funcs, s.Branches); err != nil {
+			return errors.Wrapf(err, "SaveLCOV")
 		}
-		prevroot := root
-		root = filepath.Dir(root)
-		if prevroot == root {
-			break
-		}
-		if root == "" {
-			break
-		}
-		pos := strings.Index(prevroot, root)
-		addPath = substr(prevroot, len(root)-pos, len(prevroot)-len(root)+1)
 	}
-	return "", addPath
-}
 
-func substr(input string, start int, length int) string {
-	asRunes := []rune(input)
+	if err := writeReport(setup, s, t); err != nil {
+		return errors.Wrapf(err, "Report")
+	}
 
-	if start >= len(asRunes) {
-		return ""
+	if setup.HTML != "" {
+		if err := saveReport(setup, s, t, setup.HTML, "HTML report", (*report.Report).WriteHTML); err != nil {
+			return errors.Wrapf(err, "SaveHTML")
+		}
 	}
 
-	if start+length > len(asRunes) {
-		length = len(asRunes) - start
+	if setup.SARIF != "" {
+		write := func(r *report.Report, w io.Writer) error { return r.WriteSARIF(w, time.Now()) }
+		if err := saveReport(setup, s, t, setup.SARIF, "SARIF log", write); err != nil {
+			return errors.Wrapf(err, "SaveSARIF")
+		}
 	}
 
-	return string(asRunes[start : start+length])
-}
+	if setup.Diff {
+		if err := printDiffCoverage(setup, t.Results); err != nil {
+			return errors.Wrapf(err, "Diff")
+		}
+	}
 
-func printTotalCoverage(setup *shared.Setup, fn string) {
-	currentDir, _ := setup.Env.Getwd()
-	if _, err := os.Stat(fn); os.IsNotExist(err) {
-		return // File coverage.out does not exist
-	}
-	stdout := bytes.NewBufferString("")
-	stderr := bytes.NewBufferString("")
-	exe := exec.Command("go", "tool", "cover", "-func", fn)
-	exe.Dir = currentDir
-	exe.Env = setup.Env.Environ()
-	exe.Stdout = stdout
-	exe.Stderr = stderr
-	err := exe.Run()
-	if err != nil {
-		fmt.Printf("%v not found.", fn)
-		os.Exit(1)
+	// failed tests take precedence over missing coverage
+	if err := t.Enforce(); err != nil && testErr == nil {
+		return errors.Wrapf(err, "Enforce")
 	}
 
-	str := stdout.String()
-	strs := strings.Split(str, "\n")
-	if len(strs) > 0 {
-		s := strs[len(strs)-2]
-		strt := strings.Split(s, "\t")
-		if len(strt) > 0 {
-			ps := strt[len(strt)-1]
-			fmt.Printf("coverage: %s of statements", ps)
+	// other formats have already been written
+	if setup.TextOutput() {
+		if setup.Notest || setup.Notestdept {
+			printNotCoverLinks(setup, tester.UncoverageFileName, false)
+		} else if !setup.Diff {
+			if err := printCoverage(setup, s, t); err != nil {
+				return errors.Wrapf(err, "Total")
+			}
 		}
 	}
+
+	return testErr
 }
 
-// Run initiates the command with the provided setup
-func Run(setup *shared.Setup) error {
-	if err := setup.Parse(flag.Args()); err != nil {
+// scan parses the package arguments and scans the packages for exclusions
+func scan(setup *shared.Setup, s *scanner.CodeMap, args []string) error {
+	if err := setup.Parse(args); err != nil {
 		return errors.Wrapf(err, "Parse")
 	}
-
-	s := scanner.New(setup)
 	if err := s.LoadProgram(); err != nil {
 		return errors.Wrapf(err, "LoadProgram")
 	}
 	if err := s.ScanPackages(); err != nil {
 		return errors.Wrapf(err, "ScanPackages")
 	}
+	return nil
+}
 
-	t := tester.New(setup)
+// scanModules scans the packages of every module in the working dir and its
+// subdirectories from the root of the module, because the go command does
+// not cross module boundaries. The package arguments are rebased onto each
+// module, and modules that none of them reach are skipped. The packages are
+// registered in the cache of the working dir, so their files can be found
+// when the results are merged.
+func scanModules(setup *shared.Setup, s *scanner.CodeMap) error {
+	modules, err := shared.NestedModules(setup.Env)
+	if err != nil {
+		return errors.Wrapf(err, "NestedModules")
+	}
+	if len(modules) == 0 {
+		return &shared.UsageError{Err: errors.New("Error - no modules found")}
+	}
+	wd, err := setup.Env.Getwd()
+	if err != nil {
+		// notest
+		return errors.Wrap(err, "Error getting working dir")
+	}
+	defer setup.Env.Setwd(wd)
 
-	if !(setup.Notest || setup.Notestdept) {
-		if setup.Load == "" {
-			if err := t.Test(); err != nil {
-				return errors.Wrapf(err, "Test")
-			}
-		} else {
-			if err := t.Load(); err != nil {
-				return errors.Wrapf(err, "Load")
-			}
+	var all []shared.PackageSpec
+	var scanned []shared.Module
+	for _, m := range modules {
+		args := modulePatterns(setup.Args, wd, m, modules)
+		if len(args) == 0 {
+			continue
+		}
+		if err := setup.Env.Setwd(m.Dir); err != nil {
+			// notest
+			return errors.Wrapf(err, "Error changing dir to %s", m.Dir)
+		}
+		setup.Packages = nil
+		if err := scan(setup, s, args); err != nil {
+			return errors.Wrapf(err, "Module %s", m.Path)
+		}
+		scanned = append(scanned, m)
+		for _, spec := range setup.Packages {
+			spec.Module = m.Dir
+			all = append(all, spec)
 		}
 	}
-
-	if err := t.ProcessExcludes(s.Excludes); err != nil {
-		return errors.Wrapf(err, "ProcessExcludes")
+	if err := setup.Env.Setwd(wd); err != nil {
+		// notest
+		return errors.Wrap(err, "Error changing dir back")
+	}
+	if len(scanned) == 0 {
+		return &shared.UsageError{Err: errors.New("Package to test not found")}
 	}
+	setup.Packages = all
+	setup.Modules = scanned
+	setup.Paths.Register(all)
+	return nil
+}
 
-	if !(setup.Notest || setup.Notestdept) {
-		if err := t.Save(); err != nil {
-			return errors.Wrapf(err, "Save")
+// modulePatterns rebases package patterns, relative to the working dir or
+// import paths, onto the dir of a module. A "/..." pattern reaches the
+// modules below its dir too, any other pattern only the innermost module
+// that contains it. Patterns that don't reach the module are left out.
+func modulePatterns(args []string, wd string, m shared.Module, modules []shared.Module) []string {
+	if len(args) == 0 {
+		args = []string{"./..."}
+	}
+	var out []string
+	for _, arg := range args {
+		arg = strings.TrimSuffix(arg, "/")
+		recursive := arg == "..." || strings.HasSuffix(arg, "/...")
+		target := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+		// the location of a module that the target is compared with
+		loc := func(m shared.Module) string { return m.Path }
+		rel := func(base, target string) (string, bool) {
+			if base == target {
+				return ".", true
+			}
+			if base == "" || strings.HasPrefix(target, base+"/") {
+				return strings.TrimPrefix(target, base+"/"), true
+			}
+			return "", false
+		}
+		if arg == "." || strings.HasPrefix(arg, "./") || strings.HasPrefix(arg, "../") {
+			target = filepath.Join(wd, target)
+			loc = func(m shared.Module) string { return m.Dir }
+			rel = func(base, target string) (string, bool) {
+				r, err := filepath.Rel(base, target)
+				if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+					return "", false
+				}
+				return filepath.ToSlash(r), true
+			}
 		}
+
+		if _, ok := rel(target, loc(m)); ok && recursive {
+			out = append(out, "./...")
+			continue
+		}
+		r, ok := rel(loc(m), target)
+		if !ok {
+			continue
+		}
+		// the target belongs to a nested module inside of m
+		inner := false
+		for _, n := range modules {
+			if _, ok := rel(loc(m), loc(n)); !ok || loc(n) == loc(m) {
+				continue
+			}
+			if _, ok := rel(loc(n), target); ok {
+				inner = true
+			}
+		}
+		if inner {
+			continue
+		}
+		pattern := "./" + r
+		if r == "." {
+			pattern = "."
+		}
+		if recursive {
+			pattern += "/..."
+		}
+		out = append(out, pattern)
 	}
+	return out
+}
 
-	if setup.Notest {
-		if err := t.SaveUn(shared.Notest); err != nil {
-			return errors.Wrapf(err, "SaveUn")
+// isFlagSet returns true if the flag was set on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
+	})
+	return set
+}
+
+// writeReport writes the report to stdout if a format other than text is
+// selected
+func writeReport(setup *shared.Setup, s *scanner.CodeMap, t *tester.Tester) error {
+	if setup.TextOutput() {
+		return nil
 	}
-	if setup.Notestdept {
-		if err := t.SaveUn(shared.Notestdept); err != nil {
-			return errors.Wrapf(err, "SaveUn")
+	r, err := newReport(setup, s, t)
+	if err != nil {
+		return err
+	}
+	switch setup.Format {
+	case shared.FormatMarkdown:
+		var baseline map[string]report.Summary
+		if setup.Baseline != "" {
+			profiles, err := cover.ParseProfiles(setup.Baseline)
+			if err != nil {
+				return &shared.UsageError{Err: errors.Wrapf(err, "Error loading baseline %s", setup.Baseline)}
+			}
+			baseline = report.PackageSummaries(profiles)
 		}
+		return r.WriteMarkdown(setup.Env.Stdout(), baseline, setup.LinkBase)
+	case shared.FormatGHA:
+		return r.WriteGHA(setup.Env.Stdout())
+	case shared.FormatLint:
+		return r.WriteLint(setup.Env.Stdout())
+	default:
+		return r.WriteJSON(setup.Env.Stdout())
 	}
+}
 
-	if err := t.Enforce(); err != nil {
-		return errors.Wrapf(err, "Enforce")
+// saveReport writes the report to a file with the provided writer
+func saveReport(setup *shared.Setup, s *scanner.CodeMap, t *tester.Tester, fname, kind string, write func(*report.Report, io.Writer) error) error {
+	r, err := newReport(setup, s, t)
+	if err != nil {
+		return err
 	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating %s %s", kind, fname)
+	}
+	defer f.Close()
+	return write(r, f)
+}
 
+func newReport(setup *shared.Setup, s *scanner.CodeMap, t *tester.Tester) (*report.Report, error) {
+	r, err := report.New(setup, t)
+	if err != nil {
+		return nil, err
+	}
+	r.AddDirectives(s.Directives)
+	r.AddFuncs(s.Funcs)
+	return r, nil
+}
+
+// printDiffCoverage reports the coverage of the lines changed since
+// setup.Base and fails if it is below setup.Threshold. With a machine
+// readable format the text is written to stderr.
+func printDiffCoverage(setup *shared.Setup, results []*cover.Profile) error {
+	d := differ.New(setup)
+	if err := d.Load(); err != nil {
+		return errors.Wrapf(err, "Load")
+	}
+	r, err := d.Compare(results)
+	if err != nil {
+		return errors.Wrapf(err, "Compare")
+	}
+	out := setup.Env.Stdout()
+	if !setup.TextOutput() {
+		// keep stdout for the document of the output format
+		out = setup.Env.Stderr()
+	}
+	if len(r.Uncovered) > 0 {
+		fmt.Fprintln(out, "--------------------------------------------\t\n"+
+			"The following changed lines are not tested:\t\n"+
+			"--------------------------------------------")
+		for _, l := range r.Uncovered {
+			fmt.Fprintf(out, "%s:%d\n", setup.DisplayPath(l.File), l.Line)
+		}
+	}
+	if r.Statements == 0 {
+		fmt.Fprintf(out, "No changed statements since %s\n", setup.Base)
+		return nil
+	}
+	fmt.Fprintf(out, "changed lines coverage: %.1f%% of %d statements\n", r.Percent(), r.Statements)
+
+	if setup.Threshold > 0 && r.Percent() < setup.Threshold {
+		return &shared.CoverageError{Err: errors.Errorf("Error - changed lines coverage %.1f%% is below the threshold of %.1f%%", r.Percent(), setup.Threshold)}
+	}
 	return nil
 }
 
@@ -394,3 +685,31 @@ func (v *argsValue) Set(s string) error {
 	v.args = append(v.args, s)
 	return nil
 }
+
+var formats = []string{shared.FormatText, shared.FormatJSON, shared.FormatMarkdown, shared.FormatGHA, shared.FormatLint}
+
+// choiceValue is a flag value that must be one of a list of choices
+type choiceValue struct {
+	name    string
+	choices []string
+	value   string
+}
+
+var _ flag.Value = (*choiceValue)(nil)
+
+func (v *choiceValue) String() string {
+	// notest
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+func (v *choiceValue) Set(s string) error {
+	for _, c := range v.choices {
+		if s == c {
+			v.value = s
+			return nil
+		}
+	}
+	return errors.Errorf("unknown %s %q, expected one of: %s", v.name, s, strings.Join(v.choices, ", "))
+}
@@ -7,13 +7,18 @@ import (
 	"path/filepath"
 
 	"bytes"
+	"reflect"
+	"sort"
 	"strings"
 
 	"os"
+	"os/exec"
 
 	"github.com/heeus/gocov/shared"
 	"github.com/heeus/gocov/shared/builder"
 	"github.com/heeus/gocov/shared/vos"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/cover"
 )
 
 func TestRun(t *testing.T) {
@@ -193,3 +198,269 @@ ns/a/a.go:8.24,11.5 2 1
 		})
 	}
 }
+
+func TestRun_load_and_test(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %s", err)
+	}
+	defer b.Cleanup()
+
+	_, adir, err := b.Package("a", map[string]string{
+		"a.go": "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+		"a_test.go": "package a\n\nimport \"testing\"\n\n" +
+			"func TestFoo(t *testing.T) {\n\tFoo(1)\n}\n",
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %s", err)
+	}
+	if _, _, err := b.Package("c", map[string]string{
+		"c.go": "package c\n\nfunc Baz() int {\n\treturn 1\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %s", err)
+	}
+	root := filepath.Dir(adir)
+	// e.g. the profile of an integration test, that covers c
+	if err := b.File("", "c.out", "mode: set\nns/c/c.go:3.16,5.2 1 1\n"); err != nil {
+		t.Fatalf("Error creating profile: %s", err)
+	}
+	if err := env.Setwd(root); err != nil {
+		t.Fatalf("Error in Setwd: %s", err)
+	}
+	// filepath.Glob in "Load" does not respect the mocked working directory
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("Error in os.Chdir: %s", err)
+	}
+	env.Setstdout(&bytes.Buffer{})
+	env.Setstderr(&bytes.Buffer{})
+
+	// the tests are run too when packages are given
+	setup := &shared.Setup{
+		Env:   env,
+		Paths: shared.NewCache(env),
+		Load:  "*.out",
+		Args:  []string{"./..."},
+	}
+	if err := Run(setup); err != nil {
+		t.Fatalf("Error running program: %+v", err)
+	}
+	coverage, err := os.ReadFile(filepath.Join(root, "coverage.out"))
+	if err != nil {
+		t.Fatalf("Error reading coverage file: %s", err)
+	}
+	// a is covered by its test and c by the loaded profile
+	covered := map[string]bool{}
+	for _, line := range strings.Split(string(coverage), "\n") {
+		if strings.HasSuffix(line, " 1") {
+			covered[strings.Split(line, ":")[0]] = true
+		}
+	}
+	if !covered["ns/a/a.go"] || !covered["ns/c/c.go"] {
+		t.Fatalf("Error in coverage - expected a.go and c.go to be covered:\n%s", coverage)
+	}
+}
+
+func TestPrintDiffCoverage_threshold(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %s", err)
+	}
+	defer b.Cleanup()
+
+	_, pdir, err := b.Package("a", map[string]string{
+		"a.go": "package a\n\nfunc Foo(i int) int {\n\treturn i\n}\n",
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %s", err)
+	}
+	git := func(args ...string) {
+		exe := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		exe.Dir = b.Root()
+		if out, err := exe.CombinedOutput(); err != nil {
+			t.Fatalf("Error executing git %v: %s\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	changed := "package a\n\nfunc Foo(i int) int {\n\treturn i\n}\n\nfunc Bar(i int) int {\n\ti++\n\treturn i\n}\n"
+	if err := os.WriteFile(filepath.Join(pdir, "a.go"), []byte(changed), 0666); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	if err := env.Setwd(b.Root()); err != nil {
+		t.Fatalf("Error in Setwd: %s", err)
+	}
+	sout := &bytes.Buffer{}
+	env.Setstdout(sout)
+
+	// Bar is changed and covered by one of its two statements
+	results := []*cover.Profile{{
+		FileName: "ns/a/a.go",
+		Blocks: []cover.ProfileBlock{
+			{StartLine: 3, EndLine: 5, NumStmt: 1, Count: 0},
+			{StartLine: 7, EndLine: 8, NumStmt: 1, Count: 1},
+			{StartLine: 9, EndLine: 10, NumStmt: 1, Count: 0},
+		},
+	}}
+	setup := &shared.Setup{
+		Env:       env,
+		Paths:     shared.NewCache(env),
+		Base:      "HEAD",
+		Threshold: 80,
+	}
+	err = printDiffCoverage(setup, results)
+	var coverageErr *shared.CoverageError
+	if !errors.As(err, &coverageErr) {
+		t.Fatalf("Error - expected a coverage error, got %+v", err)
+	}
+	if !strings.Contains(sout.String(), "changed lines coverage: 50.0% of 2 statements") {
+		t.Fatalf("Error in output:\n%s", sout.String())
+	}
+
+	setup.Threshold = 50
+	sout.Reset()
+	if err := printDiffCoverage(setup, results); err != nil {
+		t.Fatalf("Error - expected no error at the threshold, got %+v", err)
+	}
+}
+
+func TestWatch_cycle(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %s", err)
+	}
+	defer b.Cleanup()
+
+	adir := ""
+	for name, files := range map[string]map[string]string{
+		"a": {
+			"a.go":      "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+			"a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestFoo(t *testing.T) {\n\tFoo(1)\n}\n",
+		},
+		// b only imports a in its test
+		"b": {
+			"b.go":      "package b\n",
+			"b_test.go": "package b\n\nimport (\n\t\"testing\"\n\n\t\"ns/a\"\n)\n\nfunc TestB(t *testing.T) {\n\ta.Foo(1)\n}\n",
+		},
+		"c": {
+			"c.go":      "package c\n\nfunc Baz() int {\n\treturn 1\n}\n",
+			"c_test.go": "package c\n\nimport \"testing\"\n\nfunc TestBaz(t *testing.T) {\n\tBaz()\n}\n",
+		},
+	} {
+		_, dir, err := b.Package(name, files)
+		if err != nil {
+			t.Fatalf("Error creating package: %s", err)
+		}
+		if name == "a" {
+			adir = dir
+		}
+	}
+	if err := env.Setwd(b.Root()); err != nil {
+		t.Fatalf("Error in Setwd: %s", err)
+	}
+	sout := &bytes.Buffer{}
+	env.Setstdout(sout)
+
+	setup := &shared.Setup{Env: env, Paths: shared.NewCache(env)}
+	wt, err := newWatch(setup)
+	if err != nil {
+		t.Fatalf("Error starting watch: %+v", err)
+	}
+	if err := wt.start(); err != nil {
+		t.Fatalf("Error in first run: %+v", err)
+	}
+	if len(wt.t.Tests) != 3 {
+		t.Fatalf("Error in first run - expected 3 tested packages, got %d", len(wt.t.Tests))
+	}
+
+	// Bar moves Foo down, so the old blocks of a.go no longer fit the file
+	changed := "package a\n\nfunc Bar(i int) int {\n\tif i > 0 {\n\t\t// notest\n\t\treturn i\n\t}\n\treturn 0\n}\n\n" +
+		"func Foo(i int) int {\n\treturn i + 1\n}\n"
+	if err := os.WriteFile(filepath.Join(adir, "a.go"), []byte(changed), 0666); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	sout.Reset()
+	affected, err := wt.cycle()
+	if err != nil {
+		t.Fatalf("Error in cycle: %+v", err)
+	}
+
+	// a changed and b depends on it, c is not rerun
+	var rerun, tested []string
+	for _, spec := range affected {
+		rerun = append(rerun, spec.Path)
+	}
+	for _, pkg := range wt.t.Tests {
+		tested = append(tested, pkg.Path)
+	}
+	sort.Strings(tested)
+	if !reflect.DeepEqual(rerun, []string{"ns/a", "ns/b"}) || !reflect.DeepEqual(tested, rerun) {
+		t.Fatalf("Error in rerun packages - got %v, tested %v", rerun, tested)
+	}
+	if !strings.HasPrefix(sout.String(), "\nChanged: ns/a. Running tests in 2 package(s)\n") {
+		t.Fatalf("Error in output:\n%s", sout.String())
+	}
+	if len(wt.s.Excludes[filepath.Join(adir, "a.go")]) == 0 {
+		t.Fatal("Error - the notest comment of the changed file was not rescanned")
+	}
+
+	// the uncovered lines follow, without an error of the test run
+	if lines := strings.Split(strings.TrimSpace(sout.String()), "\n"); len(lines) < 2 || !strings.HasPrefix(lines[1], "-----") {
+		t.Fatalf("Error in cycle output:\n%s", sout.String())
+	}
+	// the old profiles of a.go are replaced: Bar is not called, so only the
+	// blocks of Foo at its new position are covered
+	files := map[string]bool{}
+	for _, p := range wt.t.Results {
+		files[p.FileName] = true
+		if p.FileName != "ns/a/a.go" {
+			continue
+		}
+		covered := 0
+		for _, b := range p.Blocks {
+			if b.Count > 0 && b.StartLine < 11 {
+				t.Fatalf("Error in results - stale block in a.go: %#v", p.Blocks)
+			}
+			if b.Count > 0 {
+				covered++
+			}
+		}
+		if covered == 0 {
+			t.Fatalf("Error in results - Foo is not covered: %#v", p.Blocks)
+		}
+	}
+	// the profile of c is kept
+	if !files["ns/a/a.go"] || !files["ns/c/c.go"] {
+		t.Fatalf("Error in results - got files %v", files)
+	}
+}
+
+func TestModulePatterns(t *testing.T) {
+	wd := filepath.FromSlash("/w")
+	modules := []shared.Module{
+		{Path: "ns", Dir: wd},
+		{Path: "ns/pkg/x", Dir: filepath.Join(wd, "pkg", "x")},
+		{Path: "ns/tools", Dir: filepath.Join(wd, "tools")},
+	}
+	tests := []struct {
+		args     []string
+		expected [][]string // by module
+	}{
+		{nil, [][]string{{"./..."}, {"./..."}, {"./..."}}},
+		{[]string{"./pkg/..."}, [][]string{{"./pkg/..."}, {"./..."}, nil}},
+		{[]string{"./pkg/x/sub"}, [][]string{nil, {"./sub"}, nil}},
+		{[]string{"./tools/...", "."}, [][]string{{"."}, nil, {"./..."}}},
+		{[]string{"ns/tools/..."}, [][]string{nil, nil, {"./..."}}},
+		{[]string{"ns/pkg/..."}, [][]string{{"./pkg/..."}, {"./..."}, nil}},
+	}
+	for _, test := range tests {
+		for i, m := range modules {
+			if got := modulePatterns(test.args, wd, m, modules); !reflect.DeepEqual(got, test.expected[i]) {
+				t.Fatalf("Error in patterns of %v in %s - got %#v, expected %#v", test.args, m.Path, got, test.expected[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// annotation is a finding attached to a range of a file
+type annotation struct {
+	Path      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	Severity  string // warning or notice
+	Message   string
+}
+
+// annotations returns the uncovered and excluded ranges of every file, sorted
+// by file and line
+func (r *Report) annotations() []annotation {
+	var out []annotation
+	for _, rg := range r.uncoveredRanges() {
+		out = append(out, annotation{
+			Path:      strings.TrimPrefix(rg.File.Path, "./"),
+			StartLine: rg.StartLine,
+			StartCol:  rg.StartCol,
+			EndLine:   rg.EndLine,
+			EndCol:    rg.EndCol,
+			Severity:  "warning",
+			Message:   "Not covered by tests",
+		})
+	}
+	for _, f := range r.Files {
+		for _, e := range f.ExcludedLines {
+			out = append(out, annotation{
+				Path:      strings.TrimPrefix(f.Path, "./"),
+				StartLine: e.StartLine,
+				StartCol:  1,
+				EndLine:   e.EndLine,
+				Severity:  "notice",
+				Message:   "Excluded from coverage by " + e.Category,
+			})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].StartLine < out[j].StartLine
+	})
+	return out
+}
+
+// WriteGHA writes the uncovered and excluded ranges as GitHub Actions
+// workflow commands, so that they show up as annotations on the diff
+func (r *Report) WriteGHA(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	for _, a := range r.annotations() {
+		fmt.Fprintf(b, "::%s file=%s,line=%d,endLine=%d", a.Severity, ghaProperty(a.Path), a.StartLine, a.EndLine)
+		if a.EndCol > 0 && a.StartLine == a.EndLine {
+			// columns are only allowed on single line annotations
+			fmt.Fprintf(b, ",col=%d,endColumn=%d", a.StartCol, a.EndCol)
+		}
+		fmt.Fprintf(b, ",title=gocov::%s\n", ghaData(a.Message))
+	}
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing annotations")
+	}
+	return nil
+}
+
+// WriteLint writes the uncovered and excluded ranges as
+// "file:line:col: severity: message" lines, as used by compilers and linters
+func (r *Report) WriteLint(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	for _, a := range r.annotations() {
+		fmt.Fprintf(b, "%s:%d:%d: %s: %s (lines %d-%d)\n", a.Path, a.StartLine, a.StartCol, a.Severity, a.Message, a.StartLine, a.EndLine)
+	}
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing annotations")
+	}
+	return nil
+}
+
+var (
+	ghaDataReplacer     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	ghaPropertyReplacer = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func ghaData(s string) string {
+	return ghaDataReplacer.Replace(s)
+}
+
+func ghaProperty(s string) string {
+	return ghaPropertyReplacer.Replace(s)
+}
@@ -0,0 +1,221 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Line classes of the HTML report
+const (
+	lineCovered   = "covered"
+	lineUncovered = "uncovered"
+)
+
+type htmlPage struct {
+	Total Summary
+	Tree  []*htmlNode
+	Files []*htmlFile
+}
+
+// htmlNode is a directory or file in the tree of the HTML report
+type htmlNode struct {
+	Name string
+	ID   string // empty for directories
+	Summary
+	Children []*htmlNode
+}
+
+type htmlFile struct {
+	ID   string
+	Path string
+	Summary
+	Lines []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string
+	Title  string
+}
+
+// WriteHTML writes the report as a self-contained HTML page, with a file tree
+// and the annotated source of every file. Sources are read from disk.
+func (r *Report) WriteHTML(w io.Writer) error {
+	page := htmlPage{Total: r.Total}
+	root := &htmlNode{}
+	for i, f := range r.Files {
+		src, err := os.ReadFile(f.fpath)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading %s", f.fpath)
+		}
+		hf := &htmlFile{
+			ID:      fmt.Sprintf("file%d", i),
+			Path:    f.Path,
+			Summary: f.Summary,
+			Lines:   annotate(f, string(src)),
+		}
+		page.Files = append(page.Files, hf)
+		root.add(strings.Split(strings.TrimPrefix(f.Path, "./"), "/"), hf)
+	}
+	root.sort()
+	page.Tree = root.Children
+	if err := htmlTemplate.Execute(w, page); err != nil {
+		return errors.Wrap(err, "Error writing HTML report")
+	}
+	return nil
+}
+
+// annotate splits the source into lines and sets the class and hover text of
+// each line. Uncovered code wins over excluded code, which wins over covered
+// code.
+func annotate(f *File, src string) []htmlLine {
+	lines := make([]htmlLine, 0, strings.Count(src, "\n")+1)
+	for i, text := range strings.Split(strings.TrimSuffix(src, "\n"), "\n") {
+		lines = append(lines, htmlLine{Number: i + 1, Text: text})
+	}
+	set := func(start, end int, class, title string) {
+		for n := start; n <= end && n <= len(lines); n++ {
+			if n < 1 || lines[n-1].Class == lineUncovered {
+				continue
+			}
+			lines[n-1].Class = class
+			lines[n-1].Title = title
+		}
+	}
+	for _, b := range f.Blocks {
+		if b.Statements == 0 || b.Count == 0 {
+			continue
+		}
+		set(b.StartLine, b.EndLine, lineCovered, "")
+	}
+	for _, e := range f.ExcludedLines {
+		set(e.StartLine, e.EndLine, e.Category, "excluded by "+e.Category)
+	}
+	// directives are sorted by start line, so inner scopes are applied last
+	for _, d := range f.Directives {
+		title := fmt.Sprintf("%s, %s scope, lines %d-%d: %s", d.Category, d.Scope, d.StartLine, d.EndLine, d.Text)
+		set(d.StartLine, d.EndLine, d.Category, title)
+	}
+	for _, b := range f.Blocks {
+		if b.Statements == 0 || b.Count > 0 {
+			continue
+		}
+		for n := b.StartLine; n <= b.EndLine && n <= len(lines); n++ {
+			lines[n-1].Class = lineUncovered
+			lines[n-1].Title = "not covered by tests"
+		}
+	}
+	return lines
+}
+
+// add inserts a file into the tree, creating directories on the way and
+// adding the statement counts of the file to each of them
+func (n *htmlNode) add(parts []string, f *htmlFile) {
+	n.Statements += f.Statements
+	n.Covered += f.Covered
+	n.Percent = percent(n.Covered, n.Statements)
+	if len(parts) == 1 {
+		n.Children = append(n.Children, &htmlNode{Name: parts[0], ID: f.ID, Summary: f.Summary})
+		return
+	}
+	for _, c := range n.Children {
+		if c.ID == "" && c.Name == parts[0] {
+			c.add(parts[1:], f)
+			return
+		}
+	}
+	dir := &htmlNode{Name: parts[0]}
+	n.Children = append(n.Children, dir)
+	dir.add(parts[1:], f)
+}
+
+// sort orders directories before files, each by name
+func (n *htmlNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if (a.ID == "") != (b.ID == "") {
+			return a.ID == ""
+		}
+		return a.Name < b.Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(p float64) string { return fmt.Sprintf("%.1f%%", p) },
+	"level": func(p float64) string {
+		switch {
+		case p >= 80:
+			return "high"
+		case p >= 50:
+			return "medium"
+		default:
+			return "low"
+		}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gocov coverage report</title>
+<style>
+body { margin: 0; display: flex; height: 100vh; font-family: sans-serif; font-size: 14px; }
+nav { width: 320px; overflow: auto; border-right: 1px solid #ccc; padding: 8px; flex-shrink: 0; }
+main { flex: 1; overflow: auto; }
+nav ul { list-style: none; margin: 0; padding-left: 14px; }
+nav > ul { padding-left: 0; }
+nav a { color: #036; text-decoration: none; }
+nav a.selected { font-weight: bold; }
+summary { cursor: pointer; }
+.pct { float: right; font-size: 12px; }
+.high { color: #080; } .medium { color: #a60; } .low { color: #c00; }
+.legend span { display: inline-block; padding: 0 4px; margin: 4px 4px 0 0; }
+h2 { font-size: 16px; margin: 0; padding: 8px; background: #eee; position: sticky; top: 0; }
+section { display: none; }
+section.selected { display: block; }
+pre { margin: 0; tab-size: 4; }
+pre span { display: block; padding-right: 8px; }
+pre i { display: inline-block; width: 48px; padding-right: 8px; text-align: right; color: #999; font-style: normal; user-select: none; }
+.covered { background: #dfd; }
+.uncovered { background: #fdd; }
+.notest { background: #eee; color: #666; }
+.notestdept { background: #fec; color: #666; }
+</style>
+</head>
+<body>
+<nav>
+<div><b>Total</b> <span class="pct {{level .Total.Percent}}">{{percent .Total.Percent}}</span></div>
+<div>{{.Total.Covered}} of {{.Total.Statements}} statements covered</div>
+<div class="legend"><span class="covered">covered</span><span class="uncovered">not covered</span><span class="notest">notest</span><span class="notestdept">notestdept</span></div>
+<hr>
+{{template "tree" .Tree}}
+</nav>
+<main>
+{{range .Files}}<section id="{{.ID}}">
+<h2>{{.Path}} <span class="pct {{level .Percent}}">{{percent .Percent}}</span></h2>
+<pre>{{range .Lines}}<span{{with .Class}} class="{{.}}"{{end}}{{with .Title}} title="{{.}}"{{end}}><i>{{.Number}}</i>{{.Text}}</span>{{end}}</pre>
+</section>
+{{end}}</main>
+<script>
+function show() {
+	var id = location.hash.slice(1) || "file0";
+	document.querySelectorAll("section, nav a").forEach(function (e) {
+		e.classList.toggle("selected", e.id === id || e.getAttribute("href") === "#" + id);
+	});
+}
+window.addEventListener("hashchange", show);
+show();
+</script>
+</body>
+</html>
+{{define "tree"}}<ul>{{range .}}<li>{{if .ID}}<a href="#{{.ID}}">{{.Name}}</a> <span class="pct {{level .Percent}}">{{percent .Percent}}</span>{{else}}<details open><summary>{{.Name}} <span class="pct {{level .Percent}}">{{percent .Percent}}</span></summary>{{template "tree" .Children}}</details>{{end}}</li>{{end}}</ul>{{end}}
+`))
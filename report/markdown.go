@@ -0,0 +1,106 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// markdownTop is the number of uncovered ranges listed in the Markdown report
+const markdownTop = 10
+
+// WriteMarkdown writes a summary of the report as Markdown, suitable for a
+// pull request comment: the total, a table of packages and the largest
+// uncovered ranges. If baseline is not nil, the change of each package
+// against it is shown too. If linkBase is set, e.g. to a blob URL of the
+// repository, file:line references link to linkBase + path.
+func (r *Report) WriteMarkdown(w io.Writer, baseline map[string]Summary, linkBase string) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintf(b, "**Coverage: %.1f%%** (%d of %d statements", r.Total.Percent, r.Total.Covered, r.Total.Statements)
+	if r.Total.Excluded > 0 {
+		fmt.Fprintf(b, ", %d excluded", r.Total.Excluded)
+	}
+	fmt.Fprint(b, ")")
+	if baseline != nil {
+		var base Summary
+		for _, s := range baseline {
+			base.Statements += s.Statements
+			base.Covered += s.Covered
+		}
+		fmt.Fprintf(b, " %s", change(r.Total.Percent, percent(base.Covered, base.Statements), true))
+	}
+	fmt.Fprint(b, "\n\n")
+
+	if len(r.Failures) > 0 {
+		var failed []string
+		for _, f := range r.Failures {
+			failed = append(failed, "`"+f.Package+"`")
+		}
+		fmt.Fprintf(b, "Tests failed in %s\n\n", strings.Join(failed, ", "))
+	}
+
+	if baseline != nil {
+		fmt.Fprint(b, "| Package | Coverage | Change | Uncovered | Excluded |\n|---|---:|---:|---:|---:|\n")
+	} else {
+		fmt.Fprint(b, "| Package | Coverage | Uncovered | Excluded |\n|---|---:|---:|---:|\n")
+	}
+	for _, pkg := range r.Packages {
+		fmt.Fprintf(b, "| `%s`", pkg.Path)
+		if pkg.Failed {
+			fmt.Fprint(b, " (tests failed)")
+		}
+		fmt.Fprintf(b, " | %.1f%% |", pkg.Percent)
+		if baseline != nil {
+			base, ok := baseline[pkg.Path]
+			fmt.Fprintf(b, " %s |", change(pkg.Percent, base.Percent, ok))
+		}
+		fmt.Fprintf(b, " %d | %d |\n", pkg.Statements-pkg.Covered, pkg.Excluded)
+	}
+
+	ranges := r.uncoveredRanges()
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Statements > ranges[j].Statements })
+	if len(ranges) > 0 {
+		fmt.Fprintf(b, "\n<details>\n<summary>Top uncovered ranges (%d of %d)</summary>\n\n", min(len(ranges), markdownTop), len(ranges))
+		for i, rg := range ranges {
+			if i == markdownTop {
+				break
+			}
+			ref := fmt.Sprintf("%s:%d", strings.TrimPrefix(rg.File.Path, "./"), rg.StartLine)
+			if rg.EndLine > rg.StartLine {
+				ref += fmt.Sprintf("-%d", rg.EndLine)
+			}
+			if linkBase != "" {
+				ref = fmt.Sprintf("[`%s`](%s%s#L%d-L%d)", ref, linkBase, strings.TrimPrefix(rg.File.Path, "./"), rg.StartLine, rg.EndLine)
+			} else {
+				ref = "`" + ref + "`"
+			}
+			fmt.Fprintf(b, "- %s: %d %s\n", ref, rg.Statements, plural(rg.Statements, "statement"))
+		}
+		fmt.Fprint(b, "\n</details>\n")
+	}
+
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing Markdown report")
+	}
+	return nil
+}
+
+// change formats the difference between two percentages
+func change(current, base float64, ok bool) string {
+	if !ok {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", current-base)
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,556 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/heeus/gocov/shared"
+	"github.com/heeus/gocov/tester"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/cover"
+)
+
+// Report is the structured result of a gocov run
+type Report struct {
+	Files    []*File    `json:"files"`
+	Packages []*Package `json:"packages"`
+	Modules  []*Module  `json:"modules,omitempty"`
+	Total    Summary    `json:"total"`
+	Failures []Failure  `json:"failures,omitempty"`
+	Flaky    []Flaky    `json:"flaky,omitempty"`
+
+	root string // working dir that display paths are relative to
+}
+
+// Summary holds statement counts and the resulting coverage percentage.
+// Excluded statements are not part of Statements. RawPercent is the coverage
+// with excluded statements counted as uncovered.
+type Summary struct {
+	Statements         int     `json:"statements"`
+	Covered            int     `json:"covered"`
+	Percent            float64 `json:"percent"`
+	RawPercent         float64 `json:"raw_percent"`
+	Excluded           int     `json:"excluded"`
+	ExcludedNotest     int     `json:"excluded_notest"`
+	ExcludedNotestdept int     `json:"excluded_notestdept"`
+}
+
+// add adds the statement counts of another summary
+func (s *Summary) add(o Summary) {
+	s.Statements += o.Statements
+	s.Covered += o.Covered
+	s.Excluded += o.Excluded
+	s.ExcludedNotest += o.ExcludedNotest
+	s.ExcludedNotestdept += o.ExcludedNotestdept
+	s.update()
+}
+
+// exclude adds excluded statements of a category
+func (s *Summary) exclude(category string, statements int) {
+	s.Excluded += statements
+	switch category {
+	case shared.Notest.String():
+		s.ExcludedNotest += statements
+	case shared.Notestdept.String():
+		s.ExcludedNotestdept += statements
+	}
+	s.update()
+}
+
+// update sets the percentages from the statement counts
+func (s *Summary) update() {
+	s.Percent = percent(s.Covered, s.Statements)
+	s.RawPercent = percent(s.Covered, s.Statements+s.Excluded)
+}
+
+// File holds the coverage of a single source file
+type File struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Package string `json:"package"`
+	Summary
+	Blocks         []Block     `json:"blocks"`
+	CoveredLines   []Range     `json:"covered_lines"`
+	UncoveredLines []Range     `json:"uncovered_lines"`
+	ExcludedLines  []Exclusion `json:"excluded_lines"`
+	Directives     []Directive `json:"directives"`
+	Functions      []Function  `json:"functions"`
+
+	fpath    string
+	excluded []excludedBlock
+}
+
+type excludedBlock struct {
+	cover.ProfileBlock
+	Category string
+}
+
+// Function holds the coverage of a function declaration
+type Function struct {
+	Name      string `json:"name"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Summary
+}
+
+// Package holds the coverage of a package
+type Package struct {
+	Path   string `json:"path"`
+	Failed bool   `json:"failed,omitempty"` // the tests of the package failed
+	Summary
+}
+
+// Module holds the coverage of a module of a go.work workspace
+type Module struct {
+	Path     string   `json:"path"`
+	Packages []string `json:"packages"`
+	Summary
+}
+
+// Block is a coverage profile block
+type Block struct {
+	StartLine  int `json:"start_line"`
+	StartCol   int `json:"start_col"`
+	EndLine    int `json:"end_line"`
+	EndCol     int `json:"end_col"`
+	Statements int `json:"statements"`
+	Count      int `json:"count"`
+}
+
+// Range is an inclusive range of lines. Adjacent blocks are merged into a
+// single range.
+type Range struct {
+	StartLine int `json:"start_line"`
+	EndLine   int `json:"end_line"`
+}
+
+// Exclusion is a range of lines excluded from coverage
+type Exclusion struct {
+	Range
+	Category string `json:"category"`
+}
+
+// Directive is a notest or notestdept comment and the lines it excludes. Due
+// is the first date in the comment, e.g. "// notestdept 2026-12-31 reason".
+type Directive struct {
+	Range
+	Category string `json:"category"`
+	Scope    string `json:"scope"`
+	Text     string `json:"text"`
+	Due      string `json:"due,omitempty"`
+}
+
+const dateLayout = "2006-01-02"
+
+var dateRegexp = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`)
+
+// Failure is a package whose tests failed
+type Failure struct {
+	Package string `json:"package"`
+	Output  string `json:"output"`
+}
+
+// Flaky is a test that failed and passed on a retry. Attempts counts the
+// runs until it passed, including the first.
+type Flaky struct {
+	Package  string `json:"package"`
+	Test     string `json:"test"`
+	Attempts int    `json:"attempts"`
+}
+
+// New builds a Report from the results of the tester. ProcessExcludes should
+// be called first so that excluded code is accounted for.
+func New(setup *shared.Setup, t *tester.Tester) (*Report, error) {
+	r := &Report{
+		Files:    []*File{},
+		Packages: []*Package{},
+	}
+	if wd, err := setup.Env.Getwd(); err == nil {
+		r.root = wd
+	}
+	files := map[string]*File{}
+	file := func(name string) (*File, error) {
+		if f, ok := files[name]; ok {
+			return f, nil
+		}
+		fpath, err := setup.Paths.FilePath(name)
+		if err != nil {
+			return nil, err
+		}
+		f := &File{
+			Name:           name,
+			Path:           setup.DisplayPath(fpath),
+			Package:        path.Dir(name),
+			Blocks:         []Block{},
+			CoveredLines:   []Range{},
+			UncoveredLines: []Range{},
+			ExcludedLines:  []Exclusion{},
+			Directives:     []Directive{},
+			Functions:      []Function{},
+			fpath:          fpath,
+		}
+		files[name] = f
+		r.Files = append(r.Files, f)
+		return f, nil
+	}
+
+	for _, p := range t.Results {
+		f, err := file(p.FileName)
+		if err != nil {
+			return nil, err
+		}
+		var covered, uncovered []cover.ProfileBlock
+		for _, b := range p.Blocks {
+			f.Blocks = append(f.Blocks, Block{
+				StartLine:  b.StartLine,
+				StartCol:   b.StartCol,
+				EndLine:    b.EndLine,
+				EndCol:     b.EndCol,
+				Statements: b.NumStmt,
+				Count:      b.Count,
+			})
+			f.Statements += b.NumStmt
+			if b.Count > 0 {
+				f.Covered += b.NumStmt
+				covered = append(covered, b)
+			} else {
+				uncovered = append(uncovered, b)
+			}
+		}
+		f.CoveredLines = mergeRanges(covered)
+		f.UncoveredLines = mergeRanges(uncovered)
+	}
+
+	var categories []shared.ExcludeType
+	for extype := range t.Excluded {
+		categories = append(categories, extype)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i] < categories[j] })
+	for _, extype := range categories {
+		for _, p := range t.Excluded[extype] {
+			f, err := file(p.FileName)
+			if err != nil {
+				return nil, err
+			}
+			for _, b := range p.Blocks {
+				f.exclude(extype.String(), b.NumStmt)
+				f.excluded = append(f.excluded, excludedBlock{ProfileBlock: b, Category: extype.String()})
+			}
+			for _, rg := range mergeRanges(p.Blocks) {
+				f.ExcludedLines = append(f.ExcludedLines, Exclusion{Range: rg, Category: extype.String()})
+			}
+		}
+	}
+	for _, f := range files {
+		sort.SliceStable(f.ExcludedLines, func(i, j int) bool {
+			return f.ExcludedLines[i].StartLine < f.ExcludedLines[j].StartLine
+		})
+	}
+
+	sort.Slice(r.Files, func(i, j int) bool { return r.Files[i].Name < r.Files[j].Name })
+	packages := map[string]*Package{}
+	r.Total.update()
+	for _, f := range r.Files {
+		f.update()
+		pkg, ok := packages[f.Package]
+		if !ok {
+			pkg = &Package{Path: f.Package}
+			pkg.update()
+			packages[f.Package] = pkg
+			r.Packages = append(r.Packages, pkg)
+		}
+		pkg.add(f.Summary)
+		r.Total.add(f.Summary)
+	}
+	r.addModules(setup.Modules)
+
+	for _, failure := range t.Failures {
+		r.Failures = append(r.Failures, Failure{Package: failure.Package, Output: failure.Output})
+		if pkg, ok := packages[failure.Package]; ok {
+			pkg.Failed = true
+		}
+	}
+	for _, f := range t.Flaky {
+		r.Flaky = append(r.Flaky, Flaky{Package: f.Package, Test: f.Test, Attempts: f.Attempts})
+	}
+	sort.Slice(r.Flaky, func(i, j int) bool {
+		if r.Flaky[i].Package != r.Flaky[j].Package {
+			return r.Flaky[i].Package < r.Flaky[j].Package
+		}
+		return r.Flaky[i].Test < r.Flaky[j].Test
+	})
+	return r, nil
+}
+
+// addModules groups the packages by the workspace module they belong to. A
+// package belongs to the module with the longest matching path, as modules
+// can be nested.
+func (r *Report) addModules(modules []shared.Module) {
+	if len(modules) == 0 {
+		return
+	}
+	byPath := map[string]*Module{}
+	for _, pkg := range r.Packages {
+		var owner string
+		for _, m := range modules {
+			if (pkg.Path == m.Path || strings.HasPrefix(pkg.Path, m.Path+"/")) && len(m.Path) > len(owner) {
+				owner = m.Path
+			}
+		}
+		if owner == "" {
+			continue
+		}
+		m, ok := byPath[owner]
+		if !ok {
+			m = &Module{Path: owner, Packages: []string{}}
+			m.update()
+			byPath[owner] = m
+			r.Modules = append(r.Modules, m)
+		}
+		m.Packages = append(m.Packages, pkg.Path)
+		m.add(pkg.Summary)
+	}
+	sort.Slice(r.Modules, func(i, j int) bool { return r.Modules[i].Path < r.Modules[j].Path })
+}
+
+// WriteModules writes the coverage of every workspace module
+func (r *Report) WriteModules(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 1, 8, 2, ' ', 0)
+	for _, m := range r.Modules {
+		fmt.Fprintf(tw, "%s\t%d/%d\t%.1f%%\n", m.Path, m.Covered, m.Statements, m.Percent)
+	}
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing module coverage")
+	}
+	return nil
+}
+
+// WriteFlaky writes the tests that only passed on a retry
+func (r *Report) WriteFlaky(w io.Writer) error {
+	if len(r.Flaky) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "flaky tests:")
+	tw := tabwriter.NewWriter(w, 1, 8, 2, ' ', 0)
+	for _, f := range r.Flaky {
+		fmt.Fprintf(tw, "  %s\t%s\tpassed on attempt %d\n", f.Package, f.Test, f.Attempts)
+	}
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing flaky tests")
+	}
+	return nil
+}
+
+// AddDirectives attaches the directives found by the scanner, keyed by
+// absolute file path, to the files of the report
+func (r *Report) AddDirectives(directives map[string][]shared.Directive) {
+	for _, f := range r.Files {
+		for _, d := range directives[f.fpath] {
+			f.Directives = append(f.Directives, Directive{
+				Range:    Range{StartLine: d.Line, EndLine: d.EndLine},
+				Category: d.Type.String(),
+				Scope:    d.Scope,
+				Text:     d.Text,
+				Due:      dueDate(d.Text),
+			})
+		}
+		sort.SliceStable(f.Directives, func(i, j int) bool {
+			return f.Directives[i].StartLine < f.Directives[j].StartLine
+		})
+	}
+}
+
+// AddFuncs computes the coverage of the functions found by the scanner, keyed
+// by absolute file path, like 'go tool cover -func' does: a function holds
+// the blocks that are inside its declaration.
+func (r *Report) AddFuncs(funcs map[string][]shared.Func) {
+	for _, f := range r.Files {
+		for _, fn := range funcs[f.fpath] {
+			function := Function{Name: fn.Name, StartLine: fn.StartLine, EndLine: fn.EndLine}
+			inside := func(sl, sc, el, ec int) bool {
+				return (sl > fn.StartLine || sl == fn.StartLine && sc >= fn.StartCol) &&
+					(el < fn.EndLine || el == fn.EndLine && ec <= fn.EndCol)
+			}
+			for _, b := range f.Blocks {
+				if inside(b.StartLine, b.StartCol, b.EndLine, b.EndCol) {
+					function.Statements += b.Statements
+					if b.Count > 0 {
+						function.Covered += b.Statements
+					}
+				}
+			}
+			for _, b := range f.excluded {
+				if inside(b.StartLine, b.StartCol, b.EndLine, b.EndCol) {
+					function.exclude(b.Category, b.NumStmt)
+				}
+			}
+			function.update()
+			f.Functions = append(f.Functions, function)
+		}
+	}
+}
+
+// PackageSummaries returns the coverage of each package in the profiles,
+// e.g. of a baseline coverage file
+func PackageSummaries(profiles []*cover.Profile) map[string]Summary {
+	out := map[string]Summary{}
+	for _, p := range profiles {
+		s := out[path.Dir(p.FileName)]
+		for _, b := range p.Blocks {
+			s.Statements += b.NumStmt
+			if b.Count > 0 {
+				s.Covered += b.NumStmt
+			}
+		}
+		s.update()
+		out[path.Dir(p.FileName)] = s
+	}
+	return out
+}
+
+// dueDate returns the first valid date in the text of a directive
+func dueDate(text string) string {
+	for _, date := range dateRegexp.FindAllString(text, -1) {
+		if _, err := time.Parse(dateLayout, date); err == nil {
+			return date
+		}
+	}
+	return ""
+}
+
+// WriteJSON writes the report as a single indented JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return errors.Wrap(err, "Error writing JSON report")
+	}
+	return nil
+}
+
+// mergeRanges converts blocks to line ranges, merging blocks that are
+// directly after each other
+func mergeRanges(blocks []cover.ProfileBlock) []Range {
+	out := []Range{}
+	for _, b := range blocks {
+		if len(out) > 0 {
+			last := &out[len(out)-1]
+			if b.StartLine <= last.EndLine+1 {
+				if b.EndLine > last.EndLine {
+					last.EndLine = b.EndLine
+				}
+				continue
+			}
+		}
+		out = append(out, Range{StartLine: b.StartLine, EndLine: b.EndLine})
+	}
+	return out
+}
+
+func percent(covered, statements int) float64 {
+	if statements == 0 {
+		return 100
+	}
+	return float64(covered) * 100 / float64(statements)
+}
+
+// WriteFuncs writes the coverage of each function and the total, in the
+// layout of 'go tool cover -func'
+func (r *Report) WriteFuncs(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+	for _, f := range r.Files {
+		for _, fn := range f.Functions {
+			fmt.Fprintf(tw, "%s:%d:\t%s\t%.1f%%\n", f.Path, fn.StartLine, fn.Name, fn.Percent)
+		}
+	}
+	fmt.Fprintf(tw, "total:\t(statements)\t%.1f%%\n", r.Total.Percent)
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing function coverage")
+	}
+	return nil
+}
+
+// ExcludedBy describes the excluded statements by category, e.g.
+// "3 notest, 1 notestdept"
+func (s Summary) ExcludedBy() string {
+	var parts []string
+	for _, c := range []struct {
+		name  string
+		count int
+	}{
+		{shared.Notest.String(), s.ExcludedNotest},
+		{shared.Notestdept.String(), s.ExcludedNotestdept},
+	} {
+		if c.count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.count, c.name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WriteExclusions writes a table of raw and post-exclusion coverage, and of
+// the statements excluded by each category, per package and in total
+func (r *Report) WriteExclusions(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 1, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "package\tstatements\texcluded\tnotest\tnotestdept\traw\tcoverage\n")
+	row := func(name string, s Summary) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f%%\t%.1f%%\n",
+			name, s.Statements+s.Excluded, s.Excluded, s.ExcludedNotest, s.ExcludedNotestdept, s.RawPercent, s.Percent)
+	}
+	for _, pkg := range r.Packages {
+		row(pkg.Path, pkg.Summary)
+	}
+	row("total", r.Total)
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing exclusions")
+	}
+	return nil
+}
+
+// uncoveredRange is a range of uncovered code and its statement count
+type uncoveredRange struct {
+	File *File
+	Range
+	StartCol   int
+	EndCol     int
+	Statements int
+}
+
+// uncoveredRanges merges the uncovered blocks of each file into ranges, in
+// file order
+func (r *Report) uncoveredRanges() []uncoveredRange {
+	var out []uncoveredRange
+	for _, f := range r.Files {
+		current := -1
+		for _, b := range f.Blocks {
+			if b.Statements == 0 || b.Count > 0 {
+				continue
+			}
+			if current >= 0 && b.StartLine <= out[current].EndLine+1 {
+				last := &out[current]
+				if b.EndLine > last.EndLine || b.EndLine == last.EndLine && b.EndCol > last.EndCol {
+					last.EndLine = b.EndLine
+					last.EndCol = b.EndCol
+				}
+				last.Statements += b.Statements
+				continue
+			}
+			out = append(out, uncoveredRange{
+				File:       f,
+				Range:      Range{StartLine: b.StartLine, EndLine: b.EndLine},
+				StartCol:   b.StartCol,
+				EndCol:     b.EndCol,
+				Statements: b.Statements,
+			})
+			current = len(out) - 1
+		}
+	}
+	return out
+}
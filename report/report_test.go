@@ -0,0 +1,552 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heeus/gocov/report"
+	"github.com/heeus/gocov/shared"
+	"github.com/heeus/gocov/shared/builder"
+	"github.com/heeus/gocov/shared/vos"
+	"github.com/heeus/gocov/tester"
+	"golang.org/x/tools/cover"
+)
+
+// newTester returns a tester for the package ns/a with a.go and b.go, with
+// results set for both files
+func newTester(t *testing.T, gomod bool) (*shared.Setup, *tester.Tester, func()) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", gomod)
+	if err != nil {
+		t.Fatalf("Error creating builder: %s", err)
+	}
+	_, pdir, err := b.Package("a", map[string]string{
+		"a.go": "package a",
+		"b.go": "package a",
+	})
+	if err != nil {
+		b.Cleanup()
+		t.Fatalf("Error creating temp package: %s", err)
+	}
+	if err := env.Setwd(pdir); err != nil {
+		b.Cleanup()
+		t.Fatalf("Error in Setwd: %s", err)
+	}
+	setup := &shared.Setup{
+		Env:   env,
+		Paths: shared.NewCache(env),
+	}
+	ts := tester.New(setup)
+	ts.Results = []*cover.Profile{
+		{
+			FileName: "ns/a/a.go",
+			Mode:     "set",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 1, StartCol: 2, EndLine: 3, EndCol: 4, NumStmt: 2, Count: 1},
+				{StartLine: 4, StartCol: 2, EndLine: 5, EndCol: 4, NumStmt: 1, Count: 0},
+				{StartLine: 6, StartCol: 2, EndLine: 7, EndCol: 4, NumStmt: 1, Count: 0},
+				{StartLine: 10, StartCol: 2, EndLine: 11, EndCol: 4, NumStmt: 1, Count: 1},
+			},
+		},
+		{
+			FileName: "ns/a/b.go",
+			Mode:     "set",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 1, StartCol: 2, EndLine: 3, EndCol: 4, NumStmt: 3, Count: 1},
+			},
+		},
+	}
+	ts.Excluded = map[shared.ExcludeType][]*cover.Profile{
+		shared.Notestdept: {{
+			FileName: "ns/a/a.go",
+			Mode:     "set",
+			Blocks:   []cover.ProfileBlock{{StartLine: 20, EndLine: 22, NumStmt: 2}},
+		}},
+		shared.Notest: {{
+			FileName: "ns/a/a.go",
+			Mode:     "set",
+			Blocks:   []cover.ProfileBlock{{StartLine: 13, EndLine: 15, NumStmt: 1}},
+		}},
+	}
+	ts.Failures = []tester.Failure{{Package: "ns/b", Output: "FAIL"}}
+	return setup, ts, b.Cleanup
+}
+
+func TestNew(t *testing.T) {
+	for _, gomod := range []bool{true, false} {
+		t.Run(fmt.Sprintf("gomod=%v", gomod), func(t *testing.T) {
+			setup, ts, cleanup := newTester(t, gomod)
+			defer cleanup()
+
+			r, err := report.New(setup, ts)
+			if err != nil {
+				t.Fatalf("Error creating report: %+v", err)
+			}
+			if len(r.Files) != 2 {
+				t.Fatalf("Error in report - expected 2 files, got %d", len(r.Files))
+			}
+			a := r.Files[0]
+			if a.Name != "ns/a/a.go" || a.Path != "./a.go" || a.Package != "ns/a" {
+				t.Fatalf("Error in report - wrong file %s %s %s", a.Name, a.Path, a.Package)
+			}
+			expectedSummary := report.Summary{Statements: 5, Covered: 3, Percent: 60, RawPercent: 37.5, Excluded: 3, ExcludedNotest: 1, ExcludedNotestdept: 2}
+			if a.Summary != expectedSummary {
+				t.Fatalf("Error in report - file summary got %#v, expected %#v", a.Summary, expectedSummary)
+			}
+			expectedUncovered := []report.Range{{StartLine: 4, EndLine: 7}}
+			if !reflect.DeepEqual(a.UncoveredLines, expectedUncovered) {
+				t.Fatalf("Error in report - uncovered got %#v, expected %#v", a.UncoveredLines, expectedUncovered)
+			}
+			expectedCovered := []report.Range{{StartLine: 1, EndLine: 3}, {StartLine: 10, EndLine: 11}}
+			if !reflect.DeepEqual(a.CoveredLines, expectedCovered) {
+				t.Fatalf("Error in report - covered got %#v, expected %#v", a.CoveredLines, expectedCovered)
+			}
+			expectedExcluded := []report.Exclusion{
+				{Range: report.Range{StartLine: 13, EndLine: 15}, Category: "notest"},
+				{Range: report.Range{StartLine: 20, EndLine: 22}, Category: "notestdept"},
+			}
+			if !reflect.DeepEqual(a.ExcludedLines, expectedExcluded) {
+				t.Fatalf("Error in report - excluded got %#v, expected %#v", a.ExcludedLines, expectedExcluded)
+			}
+			expectedTotal := report.Summary{Statements: 8, Covered: 6, Percent: 75, RawPercent: float64(6) * 100 / 11, Excluded: 3, ExcludedNotest: 1, ExcludedNotestdept: 2}
+			if len(r.Packages) != 1 || r.Packages[0].Summary != expectedTotal || r.Total != expectedTotal {
+				t.Fatalf("Error in report - total got %#v, expected %#v", r.Total, expectedTotal)
+			}
+			if len(r.Failures) != 1 || r.Failures[0].Package != "ns/b" {
+				t.Fatalf("Error in report - wrong failures %#v", r.Failures)
+			}
+		})
+	}
+}
+
+func TestReport_WriteJSON(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteJSON(out); err != nil {
+		t.Fatalf("Error writing JSON: %+v", err)
+	}
+	decoded := &report.Report{}
+	if err := json.Unmarshal(out.Bytes(), decoded); err != nil {
+		t.Fatalf("Error decoding JSON: %s\n%s", err, out.String())
+	}
+	again := &bytes.Buffer{}
+	if err := decoded.WriteJSON(again); err != nil {
+		t.Fatalf("Error writing JSON: %+v", err)
+	}
+	if again.String() != out.String() {
+		t.Fatalf("Error in JSON round trip - got:\n%s\nexpected:\n%s\n", again.String(), out.String())
+	}
+}
+
+func TestReport_WriteHTML(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	fpath, err := setup.Paths.FilePath("ns/a/a.go")
+	if err != nil {
+		t.Fatalf("Error getting file path: %+v", err)
+	}
+	if err := os.WriteFile(fpath, []byte("package a"+strings.Repeat("\n", 21)), 0666); err != nil {
+		t.Fatalf("Error writing source: %s", err)
+	}
+	r.AddDirectives(map[string][]shared.Directive{
+		fpath: {{Type: shared.Notest, Line: 12, EndLine: 15, Text: "// notest // glue <code>", Scope: "block"}},
+	})
+	if len(r.Files[0].Directives) != 1 {
+		t.Fatalf("Error in AddDirectives - got %#v", r.Files[0].Directives)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteHTML(out); err != nil {
+		t.Fatalf("Error writing HTML: %+v", err)
+	}
+	html := out.String()
+	for _, expected := range []string{
+		`<a href="#file0">a.go</a> <span class="pct medium">60.0%</span>`,
+		`<a href="#file1">b.go</a> <span class="pct high">100.0%</span>`,
+		`<span class="covered"><i>1</i>package a</span>`,
+		`<span class="uncovered" title="not covered by tests"><i>4</i></span>`,
+		`<span class="notestdept" title="excluded by notestdept"><i>20</i></span>`,
+		`<span class="notest" title="notest, block scope, lines 12-15: // notest // glue &lt;code&gt;"`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Fatalf("Error in HTML - expected to contain %q:\n%s", expected, html)
+		}
+	}
+}
+
+func TestReport_WriteSARIF(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	fpath, err := setup.Paths.FilePath("ns/a/a.go")
+	if err != nil {
+		t.Fatalf("Error getting file path: %+v", err)
+	}
+	r.AddDirectives(map[string][]shared.Directive{
+		fpath: {
+			{Type: shared.Notestdept, Line: 19, EndLine: 22, Text: "// notestdept 2020-01-31 flaky network", Scope: "block"},
+			{Type: shared.Notestdept, Line: 21, EndLine: 21, Text: "// notestdept 2099-01-31", Scope: "block"},
+			{Type: shared.Notest, Line: 30, EndLine: 31, Text: "// notest", Scope: "block"},
+		},
+	})
+	if r.Files[0].Directives[0].Due != "2020-01-31" {
+		t.Fatalf("Error in due date - got %q", r.Files[0].Directives[0].Due)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteSARIF(out, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Error writing SARIF: %+v", err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			OriginalURIBaseIDs map[string]struct{ URI string }
+			Results            []struct {
+				RuleID    string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI, URIBaseID string }
+						Region           struct{ StartLine, StartColumn, EndLine, EndColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("Error decoding SARIF: %s\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Error in SARIF log:\n%s", out.String())
+	}
+	var got []string
+	for _, res := range log.Runs[0].Results {
+		loc := res.Locations[0].PhysicalLocation
+		rg := loc.Region
+		got = append(got, fmt.Sprintf("%s %s:%d.%d-%d.%d", res.RuleID, loc.ArtifactLocation.URI, rg.StartLine, rg.StartColumn, rg.EndLine, rg.EndColumn))
+	}
+	expected := []string{
+		"uncovered a.go:4.2-5.4",
+		"uncovered a.go:6.2-7.4",
+		"notestdept-expired a.go:19.0-22.0",
+		"stale-exclusion a.go:30.0-31.0",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Error in SARIF results - got %#v, expected %#v", got, expected)
+	}
+	wd, err := setup.Env.Getwd()
+	if err != nil {
+		t.Fatalf("Error in Getwd: %s", err)
+	}
+	if uri := log.Runs[0].OriginalURIBaseIDs["%SRCROOT%"].URI; uri != "file://"+filepath.ToSlash(wd)+"/" {
+		t.Fatalf("Error in SARIF %%SRCROOT%% - got %q", uri)
+	}
+	if base := log.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URIBaseID; base != "%SRCROOT%" {
+		t.Fatalf("Error in SARIF uriBaseId - got %q", base)
+	}
+
+	// absolute paths are written as file URIs
+	setup.PathStyle = shared.PathsAbs
+	r, err = report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	out.Reset()
+	if err := r.WriteSARIF(out, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Error writing SARIF: %+v", err)
+	}
+	log.Runs = nil
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("Error decoding SARIF: %s\n%s", err, out.String())
+	}
+	loc := log.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation
+	if loc.URI != "file://"+filepath.ToSlash(fpath) || loc.URIBaseID != "" {
+		t.Fatalf("Error in SARIF absolute location - got %#v", loc)
+	}
+}
+
+func TestReport_WriteMarkdown(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	baseline := report.PackageSummaries([]*cover.Profile{{
+		FileName: "ns/a/a.go",
+		Blocks: []cover.ProfileBlock{
+			{StartLine: 1, EndLine: 2, NumStmt: 2, Count: 1},
+			{StartLine: 3, EndLine: 4, NumStmt: 2, Count: 0},
+		},
+	}})
+	out := &bytes.Buffer{}
+	if err := r.WriteMarkdown(out, baseline, "https://example.com/blob/main/"); err != nil {
+		t.Fatalf("Error writing Markdown: %+v", err)
+	}
+	expected := "**Coverage: 75.0%** (6 of 8 statements, 3 excluded) +25.0%\n" +
+		"\n" +
+		"Tests failed in `ns/b`\n" +
+		"\n" +
+		"| Package | Coverage | Change | Uncovered | Excluded |\n" +
+		"|---|---:|---:|---:|---:|\n" +
+		"| `ns/a` | 75.0% | +25.0% | 2 | 3 |\n" +
+		"\n" +
+		"<details>\n" +
+		"<summary>Top uncovered ranges (1 of 1)</summary>\n" +
+		"\n" +
+		"- [`a.go:4-7`](https://example.com/blob/main/a.go#L4-L7): 2 statements\n" +
+		"\n" +
+		"</details>\n"
+	if out.String() != expected {
+		t.Fatalf("Error in Markdown - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := r.WriteMarkdown(out, nil, ""); err != nil {
+		t.Fatalf("Error writing Markdown: %+v", err)
+	}
+	for _, s := range []string{"| `ns/a` | 75.0% | 2 | 3 |\n", "- `a.go:4-7`: 2 statements\n"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("Error in Markdown - expected to contain %q:\n%s", s, out.String())
+		}
+	}
+
+	// failed packages are marked
+	ts.Failures = append(ts.Failures, tester.Failure{Package: "ns/a", Output: "FAIL"})
+	r, err = report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	if !r.Packages[0].Failed {
+		t.Fatalf("Error in report - ns/a not marked as failed")
+	}
+	out.Reset()
+	if err := r.WriteMarkdown(out, nil, ""); err != nil {
+		t.Fatalf("Error writing Markdown: %+v", err)
+	}
+	if s := "| `ns/a` (tests failed) | 75.0% | 2 | 3 |\n"; !strings.Contains(out.String(), s) {
+		t.Fatalf("Error in Markdown - expected to contain %q:\n%s", s, out.String())
+	}
+}
+
+func TestReport_Annotations(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+	ts.Results[1].Blocks = append(ts.Results[1].Blocks, cover.ProfileBlock{StartLine: 5, StartCol: 3, EndLine: 5, EndCol: 9, NumStmt: 1, Count: 0})
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteGHA(out); err != nil {
+		t.Fatalf("Error writing annotations: %+v", err)
+	}
+	expected := "::warning file=a.go,line=4,endLine=7,title=gocov::Not covered by tests\n" +
+		"::notice file=a.go,line=13,endLine=15,title=gocov::Excluded from coverage by notest\n" +
+		"::notice file=a.go,line=20,endLine=22,title=gocov::Excluded from coverage by notestdept\n" +
+		"::warning file=b.go,line=5,endLine=5,col=3,endColumn=9,title=gocov::Not covered by tests\n"
+	if out.String() != expected {
+		t.Fatalf("Error in GHA output - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := r.WriteLint(out); err != nil {
+		t.Fatalf("Error writing annotations: %+v", err)
+	}
+	expected = "a.go:4:2: warning: Not covered by tests (lines 4-7)\n" +
+		"a.go:13:1: notice: Excluded from coverage by notest (lines 13-15)\n" +
+		"a.go:20:1: notice: Excluded from coverage by notestdept (lines 20-22)\n" +
+		"b.go:5:3: warning: Not covered by tests (lines 5-5)\n"
+	if out.String() != expected {
+		t.Fatalf("Error in lint output - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestReport_AddFuncs(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	fpath, err := setup.Paths.FilePath("ns/a/a.go")
+	if err != nil {
+		t.Fatalf("Error getting file path: %+v", err)
+	}
+	r.AddFuncs(map[string][]shared.Func{
+		fpath: {
+			{Name: "Foo", StartLine: 1, StartCol: 1, EndLine: 8, EndCol: 2},
+			{Name: "(*T).Bar", StartLine: 9, StartCol: 1, EndLine: 16, EndCol: 2},
+		},
+	})
+	expected := []report.Function{
+		{Name: "Foo", StartLine: 1, EndLine: 8, Summary: report.Summary{Statements: 4, Covered: 2, Percent: 50, RawPercent: 50}},
+		{Name: "(*T).Bar", StartLine: 9, EndLine: 16, Summary: report.Summary{Statements: 1, Covered: 1, Percent: 100, RawPercent: 50, Excluded: 1, ExcludedNotest: 1}},
+	}
+	if !reflect.DeepEqual(r.Files[0].Functions, expected) {
+		t.Fatalf("Error in functions - got:\n%#v\nexpected:\n%#v", r.Files[0].Functions, expected)
+	}
+	if len(r.Files[1].Functions) != 0 {
+		t.Fatalf("Error in functions - expected none in b.go, got %#v", r.Files[1].Functions)
+	}
+
+	out := &bytes.Buffer{}
+	if err := r.WriteFuncs(out); err != nil {
+		t.Fatalf("Error writing functions: %+v", err)
+	}
+	expectedOut := "./a.go:1:\tFoo\t\t50.0%\n" +
+		"./a.go:9:\t(*T).Bar\t100.0%\n" +
+		"total:\t\t(statements)\t75.0%\n"
+	if out.String() != expectedOut {
+		t.Fatalf("Error in function output - got:\n%q\nexpected:\n%q", out.String(), expectedOut)
+	}
+}
+
+func TestReport_WriteExclusions(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	if got := r.Total.ExcludedBy(); got != "1 notest, 2 notestdept" {
+		t.Fatalf("Error in ExcludedBy - got %q", got)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteExclusions(out); err != nil {
+		t.Fatalf("Error writing exclusions: %+v", err)
+	}
+	expected := "package  statements  excluded  notest  notestdept  raw    coverage\n" +
+		"ns/a     11          3         1       2           54.5%  75.0%\n" +
+		"total    11          3         1       2           54.5%  75.0%\n"
+	if out.String() != expected {
+		t.Fatalf("Error in exclusions - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestReport_WriteUncovered(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+	ts.Results[1].Blocks = append(ts.Results[1].Blocks, cover.ProfileBlock{StartLine: 5, StartCol: 2, EndLine: 5, EndCol: 9, NumStmt: 3, Count: 0})
+
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	for name, src := range map[string]string{
+		"ns/a/a.go": "package a\n\nfunc a() {\n\tif x {\n\t\ty()\n\t}\n\tz()\n}\n",
+		"ns/a/b.go": "package a\n\nfunc b() {\n\tif x {\n\t\ty()\n\t}\n}\n",
+	} {
+		fpath, err := setup.Paths.FilePath(name)
+		if err != nil {
+			t.Fatalf("Error getting file path: %+v", err)
+		}
+		if err := os.WriteFile(fpath, []byte(src), 0666); err != nil {
+			t.Fatalf("Error writing source: %s", err)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if err := r.WriteUncovered(out, 0, report.SortFile); err != nil {
+		t.Fatalf("Error writing uncovered: %+v", err)
+	}
+	header := "------------------------------------\t\n" +
+		"The following lines are not tested:\t\n" +
+		"------------------------------------\n"
+	expected := header + "./a.go:4-7\n./b.go:5\n"
+	if out.String() != expected {
+		t.Fatalf("Error in uncovered - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := r.WriteUncovered(out, 1, report.SortSize); err != nil {
+		t.Fatalf("Error writing uncovered: %+v", err)
+	}
+	expected = header +
+		"./b.go:5\n" +
+		"     4\tif x {\n" +
+		">    5\t\ty()\n" +
+		"     6\t}\n" +
+		"\n" +
+		"./a.go:4-7\n" +
+		"     3\tfunc a() {\n" +
+		">    4\t\tif x {\n" +
+		">    5\t\t\ty()\n" +
+		">    6\t\t}\n" +
+		">    7\t\tz()\n" +
+		"     8\t}\n" +
+		"\n"
+	if out.String() != expected {
+		t.Fatalf("Error in uncovered - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestReport_WriteModules(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	// ns/a belongs to the nested module, not to ns
+	setup.Modules = []shared.Module{{Path: "ns"}, {Path: "ns/a"}, {Path: "other"}}
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	if len(r.Modules) != 1 || r.Modules[0].Path != "ns/a" || !reflect.DeepEqual(r.Modules[0].Packages, []string{"ns/a"}) {
+		t.Fatalf("Error in modules - got %#v", r.Modules)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteModules(out); err != nil {
+		t.Fatalf("Error writing modules: %+v", err)
+	}
+	expected := "ns/a  6/8  75.0%\n"
+	if out.String() != expected {
+		t.Fatalf("Error in modules - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestReport_WriteFlaky(t *testing.T) {
+	setup, ts, cleanup := newTester(t, true)
+	defer cleanup()
+
+	ts.Flaky = []tester.Flaky{
+		{Package: "ns/b", Test: "TestBar", Attempts: 3},
+		{Package: "ns/a", Test: "TestFoo", Attempts: 2},
+	}
+	r, err := report.New(setup, ts)
+	if err != nil {
+		t.Fatalf("Error creating report: %+v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := r.WriteFlaky(out); err != nil {
+		t.Fatalf("Error writing flaky tests: %+v", err)
+	}
+	expected := "flaky tests:\n" +
+		"  ns/a  TestFoo  passed on attempt 2\n" +
+		"  ns/b  TestBar  passed on attempt 3\n"
+	if out.String() != expected {
+		t.Fatalf("Error in flaky tests - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,199 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SARIF rule ids
+const (
+	RuleUncovered         = "uncovered"
+	RuleNotestdeptExpired = "notestdept-expired"
+	RuleStaleExclusion    = "stale-exclusion"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           sarifRegion           `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+var sarifRules = []struct {
+	id, level, description string
+}{
+	{RuleUncovered, "warning", "Code not covered by tests"},
+	{RuleNotestdeptExpired, "warning", "notestdept exclusion past its due date"},
+	{RuleStaleExclusion, "note", "Exclusion that no longer hides uncovered code"},
+}
+
+// WriteSARIF writes the findings of the report as a SARIF 2.1.0 log: one
+// result per uncovered block, per notestdept directive with a due date before
+// now, and per directive that does not exclude any uncovered code. File URIs
+// are relative to the working dir, given as %SRCROOT% in the
+// originalUriBaseIds of the run. Files outside of it, and all files with
+// absolute display paths, get absolute file:// URIs.
+func (r *Report) WriteSARIF(w io.Writer, now time.Time) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "gocov",
+			InformationURI: "https://github.com/heeus/gocov",
+		}},
+		Results: []sarifResult{},
+	}
+	if r.root != "" {
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{
+			"%SRCROOT%": {URI: strings.TrimSuffix(fileURI(r.root), "/") + "/"},
+		}
+	}
+	index := map[string]int{}
+	for i, rule := range sarifRules {
+		sr := sarifRule{ID: rule.id, ShortDescription: sarifMessage{Text: rule.description}}
+		sr.DefaultConfiguration.Level = rule.level
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sr)
+		index[rule.id] = i
+	}
+	add := func(f *File, rule, message string, region sarifRegion) {
+		res := sarifResult{
+			RuleID:    rule,
+			RuleIndex: index[rule],
+			Level:     sarifRules[index[rule]].level,
+			Message:   sarifMessage{Text: message},
+		}
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation = r.artifactLocation(f)
+		loc.PhysicalLocation.Region = region
+		res.Locations = []sarifLocation{loc}
+		run.Results = append(run.Results, res)
+	}
+
+	today := now.Format(dateLayout)
+	for _, f := range r.Files {
+		for _, b := range f.Blocks {
+			if b.Statements == 0 || b.Count > 0 {
+				continue
+			}
+			add(f, RuleUncovered, "Not covered by tests", sarifRegion{
+				StartLine:   b.StartLine,
+				StartColumn: b.StartCol,
+				EndLine:     b.EndLine,
+				EndColumn:   b.EndCol,
+			})
+		}
+		for _, d := range f.Directives {
+			region := sarifRegion{StartLine: d.StartLine, EndLine: d.EndLine}
+			if d.Category == "notestdept" && d.Due != "" && d.Due < today {
+				add(f, RuleNotestdeptExpired, fmt.Sprintf("notestdept exclusion was due on %s: %s", d.Due, d.Text), region)
+			}
+			if !f.excludes(d.Range) {
+				add(f, RuleStaleExclusion, fmt.Sprintf("%s exclusion does not hide any uncovered code: %s", d.Category, d.Text), region)
+			}
+		}
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(log); err != nil {
+		return errors.Wrap(err, "Error writing SARIF report")
+	}
+	return nil
+}
+
+// artifactLocation returns the location of a file relative to %SRCROOT%, or
+// its file:// URI if the file is outside of the working dir or is displayed
+// with an absolute path
+func (r *Report) artifactLocation(f *File) sarifArtifactLocation {
+	if r.root != "" && !filepath.IsAbs(f.Path) {
+		rel, err := filepath.Rel(r.root, f.fpath)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return sarifArtifactLocation{URI: (&url.URL{Path: filepath.ToSlash(rel)}).String(), URIBaseID: "%SRCROOT%"}
+		}
+	}
+	return sarifArtifactLocation{URI: fileURI(f.fpath)}
+}
+
+// fileURI returns the file:// URI of an absolute path
+func fileURI(fpath string) string {
+	p := filepath.ToSlash(fpath)
+	if !strings.HasPrefix(p, "/") {
+		// notest
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// excludes returns true if any excluded code overlaps the range
+func (f *File) excludes(rg Range) bool {
+	for _, e := range f.ExcludedLines {
+		if e.StartLine <= rg.EndLine && e.EndLine >= rg.StartLine {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/heeus/gocov/shared"
+	"github.com/pkg/errors"
+)
+
+// Sort orders of WriteUncovered
+const (
+	SortFile    = "file"
+	SortSize    = "size"
+	SortPackage = "package"
+)
+
+// SortOrders lists the orders accepted by WriteUncovered
+var SortOrders = []string{SortFile, SortSize, SortPackage}
+
+// WriteUncovered writes the uncovered code as merged file:start-end ranges.
+// If context is more than zero, each range is followed by its source and
+// context lines before and after it. Ranges are sorted by file and line, by
+// size with the most statements first, or by package.
+func (r *Report) WriteUncovered(w io.Writer, context int, order string) error {
+	ranges := r.uncoveredRanges()
+	if len(ranges) == 0 {
+		return nil
+	}
+	switch order {
+	case SortSize:
+		sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Statements > ranges[j].Statements })
+	case SortPackage:
+		sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].File.Package < ranges[j].File.Package })
+	default:
+		sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].File.Path < ranges[j].File.Path })
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprint(b, "------------------------------------\t\n"+
+		"The following lines are not tested:\t\n"+
+		"------------------------------------\n")
+	sources := map[string][]string{}
+	for _, rg := range ranges {
+		fmt.Fprintf(b, "%s:%d", rg.File.Path, rg.StartLine)
+		if rg.EndLine > rg.StartLine {
+			fmt.Fprintf(b, "-%d", rg.EndLine)
+		}
+		fmt.Fprintln(b)
+		if context <= 0 {
+			continue
+		}
+		lines, ok := sources[rg.File.fpath]
+		if !ok {
+			src, err := os.ReadFile(rg.File.fpath)
+			if err != nil {
+				return errors.Wrapf(err, "Error reading source file %s", rg.File.fpath)
+			}
+			lines = strings.Split(string(src), "\n")
+			sources[rg.File.fpath] = lines
+		}
+		start := max(rg.StartLine-context, 1)
+		end := min(rg.EndLine+context, len(lines))
+		if start > end {
+			continue
+		}
+		for i, line := range shared.Undent(lines[start-1 : end]) {
+			n := start + i
+			marker := " "
+			if n >= rg.StartLine && n <= rg.EndLine {
+				marker = ">"
+			}
+			fmt.Fprintf(b, "%s%5d%s\n", marker, n, line)
+		}
+		fmt.Fprintln(b)
+	}
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing uncovered lines")
+	}
+	return nil
+}
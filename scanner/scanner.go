@@ -6,6 +6,8 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/dave/astrid"
@@ -17,9 +19,14 @@ import (
 
 // CodeMap scans a number of packages for code to exclude
 type CodeMap struct {
-	setup    *shared.Setup
-	pkgs     []*packages.Package
-	Excludes map[string]map[int]shared.ExcludeType
+	setup     *shared.Setup
+	pkgs      []*packages.Package
+	importers map[string][]string
+	Excludes  map[string]map[int]shared.ExcludeType
+	Funcs     map[string][]shared.Func
+	Branches  map[string][]shared.Branch
+	// Directives holds the notest and notestdept comments of each file
+	Directives map[string][]shared.Directive
 }
 
 // PackageMap scans a single package for code to exclude
@@ -41,6 +48,10 @@ func New(setup *shared.Setup) *CodeMap {
 	return &CodeMap{
 		setup:    setup,
 		Excludes: make(map[string]map[int]shared.ExcludeType),
+		Funcs:    make(map[string][]shared.Func),
+		Branches: make(map[string][]shared.Branch),
+
+		Directives: make(map[string][]shared.Directive),
 	}
 }
 
@@ -58,9 +69,18 @@ func (c *CodeMap) LoadProgram() error {
 	for _, p := range c.setup.Packages {
 		patterns = append(patterns, p.Path)
 	}
+	pkgs, err := c.load(patterns)
+	if err != nil {
+		return err
+	}
+	c.pkgs = pkgs
+	return nil
+}
+
+func (c *CodeMap) load(patterns []string) ([]*packages.Package, error) {
 	wd, err := c.setup.Env.Getwd()
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
 	cfg := &packages.Config{
@@ -80,9 +100,113 @@ func (c *CodeMap) LoadProgram() error {
 
 	pkgs, err := packages.Load(cfg, patterns...)
 	if err != nil {
-		return errors.Wrap(err, "Error loading config")
+		return nil, errors.Wrap(err, "Error loading config")
+	}
+	return pkgs, nil
+}
+
+// LoadGraph loads the import graph of the packages, including the imports of
+// their test files, so that Dependents can be used.
+func (c *CodeMap) LoadGraph() error {
+	var patterns []string
+	for _, p := range c.setup.Packages {
+		patterns = append(patterns, p.Path)
+	}
+	wd, err := c.setup.Env.Getwd()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	cfg := &packages.Config{
+		Dir:   wd,
+		Mode:  packages.NeedName | packages.NeedImports,
+		Env:   c.setup.Env.Environ(),
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return errors.Wrap(err, "Error loading import graph")
+	}
+	c.importers = make(map[string][]string)
+	for _, p := range pkgs {
+		if p.Name == "main" && strings.HasSuffix(p.ID, ".test") {
+			// generated test main
+			continue
+		}
+		// external test packages are attributed to the package under test
+		importer := strings.TrimSuffix(p.PkgPath, "_test")
+		for imported := range p.Imports {
+			if imported != importer {
+				c.importers[imported] = append(c.importers[imported], importer)
+			}
+		}
+	}
+	return nil
+}
+
+// Dependents returns the provided packages and all packages that import them
+// directly or indirectly, including imports from test files. LoadGraph must
+// be called first.
+func (c *CodeMap) Dependents(paths []string) []string {
+	found := map[string]bool{}
+	queue := append([]string(nil), paths...)
+	for len(queue) > 0 {
+		ppath := queue[0]
+		queue = queue[1:]
+		if found[ppath] {
+			continue
+		}
+		found[ppath] = true
+		queue = append(queue, c.importers[ppath]...)
+	}
+	var out []string
+	for ppath := range found {
+		out = append(out, ppath)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Rescan reloads the provided packages from disk and replaces their excludes
+func (c *CodeMap) Rescan(specs []shared.PackageSpec) error {
+	reload := map[string]bool{}
+	var patterns []string
+	for _, spec := range specs {
+		reload[spec.Path] = true
+		patterns = append(patterns, spec.Path)
+		for fpath := range c.Excludes {
+			if filepath.Dir(fpath) == spec.Dir {
+				delete(c.Excludes, fpath)
+				delete(c.Directives, fpath)
+			}
+		}
+		for fpath := range c.Funcs {
+			if filepath.Dir(fpath) == spec.Dir {
+				delete(c.Funcs, fpath)
+				delete(c.Branches, fpath)
+			}
+		}
+	}
+	pkgs, err := c.load(patterns)
+	if err != nil {
+		return err
+	}
+	var kept []*packages.Package
+	for _, p := range c.pkgs {
+		if !reload[p.PkgPath] {
+			kept = append(kept, p)
+		}
+	}
+	c.pkgs = append(kept, pkgs...)
+	for _, p := range pkgs {
+		pm := &PackageMap{
+			CodeMap: c,
+			pkg:     p,
+			fset:    p.Fset,
+		}
+		if err := pm.ScanPackage(); err != nil {
+			return errors.WithStack(err)
+		}
 	}
-	c.pkgs = pkgs
 	return nil
 }
 
@@ -113,10 +237,92 @@ func (p *PackageMap) ScanPackage() error {
 		if err := fm.FindExcludes(); err != nil {
 			return errors.WithStack(err)
 		}
+		fm.FindFuncs()
 	}
 	return nil
 }
 
+// FindFuncs records the functions of a single file, and the branches of its
+// if, switch and select statements
+func (f *FileMap) FindFuncs() {
+	fname := f.fset.File(f.file.Pos()).Name()
+	block := 0
+	addBranch := func(line, branch int, from, to token.Pos) {
+		start := f.fset.Position(from)
+		end := f.fset.Position(to)
+		f.Branches[fname] = append(f.Branches[fname], shared.Branch{
+			Line:      line,
+			Block:     block,
+			Branch:    branch,
+			StartLine: start.Line,
+			StartCol:  start.Column,
+			EndLine:   end.Line,
+			EndCol:    end.Column,
+		})
+	}
+	addClauses := func(stmt ast.Stmt, body *ast.BlockStmt) {
+		line := f.fset.Position(stmt.Pos()).Line
+		for i, s := range body.List {
+			addBranch(line, i, s.Pos(), s.End())
+		}
+		block++
+	}
+	ast.Inspect(f.file, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncDecl:
+			if n.Body == nil {
+				// notest
+				return false
+			}
+			start := f.fset.Position(n.Pos())
+			end := f.fset.Position(n.End())
+			f.Funcs[fname] = append(f.Funcs[fname], shared.Func{
+				Name:      funcName(n),
+				StartLine: start.Line,
+				StartCol:  start.Column,
+				EndLine:   end.Line,
+				EndCol:    end.Column,
+			})
+		case *ast.IfStmt:
+			line := f.fset.Position(n.Pos()).Line
+			addBranch(line, 0, n.Body.Pos(), n.Body.End())
+			if n.Else != nil {
+				addBranch(line, 1, n.Else.Pos(), n.Else.End())
+			}
+			block++
+		case *ast.SwitchStmt:
+			addClauses(n, n.Body)
+		case *ast.TypeSwitchStmt:
+			addClauses(n, n.Body)
+		case *ast.SelectStmt:
+			addClauses(n, n.Body)
+		}
+		return true
+	})
+}
+
+func funcName(n *ast.FuncDecl) string {
+	if n.Recv == nil || len(n.Recv.List) == 0 {
+		return n.Name.Name
+	}
+	return fmt.Sprintf("(%s).%s", recvName(n.Recv.List[0].Type), n.Name.Name)
+}
+
+func recvName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + recvName(e.X)
+	case *ast.IndexExpr:
+		return recvName(e.X)
+	case *ast.IndexListExpr:
+		return recvName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	// notest
+	return ""
+}
+
 // FindExcludes scans a single file to find code to exclude from coverage files
 func (f *FileMap) FindExcludes() error {
 	var err error
@@ -169,14 +375,19 @@ func (f *FileMap) findScope(node ast.Node, filter func(ast.Node) bool) ast.Node
 func (f *FileMap) inspectComment(cg *ast.CommentGroup) {
 	var ncstr []string
 	for i := 0; i < 2; i++ {
+		extype := shared.Notest
 		if i == 0 {
 			ncstr = []string{"//", "notest"}
 		} else {
 			ncstr = []string{"//", "notestdept"}
+			extype = shared.Notestdept
 		}
 		for _, cm := range cg.List {
-			if !strings.HasPrefix(cm.Text, strings.Join(ncstr, "")) &&
-				!strings.HasPrefix(cm.Text, strings.Join(ncstr, " ")) {
+			if !hasDirective(cm.Text, ncstr) {
+				continue
+			}
+			if i == 0 && hasDirective(cm.Text, []string{"//", "notestdept"}) {
+				// handled as notestdept
 				continue
 			}
 
@@ -194,21 +405,45 @@ func (f *FileMap) inspectComment(cg *ast.CommentGroup) {
 					// case block needs an extra line...
 					endLine++
 				}
+				last := comment.Line
 				for line := comment.Line; line < endLine; line++ {
-					if i == 0 {
-						f.addExclude(start.Filename, line, shared.Notest)
-					} else {
-						f.addExclude(start.Filename, line, shared.Notestdept)
-					}
+					f.addExclude(start.Filename, line, extype)
+					last = line
 					if f.setup.Notest || f.setup.Notestdept {
 						break
 					}
 				}
+				f.Directives[start.Filename] = append(f.Directives[start.Filename], shared.Directive{
+					Type:    extype,
+					Line:    comment.Line,
+					EndLine: last,
+					Text:    cm.Text,
+					Scope:   scopeName(scope),
+				})
 			}
 		}
 	}
 }
 
+func hasDirective(text string, ncstr []string) bool {
+	return strings.HasPrefix(text, strings.Join(ncstr, "")) ||
+		strings.HasPrefix(text, strings.Join(ncstr, " "))
+}
+
+// scopeName describes the node that a directive applies to
+func scopeName(scope ast.Node) string {
+	switch scope.(type) {
+	case *ast.File:
+		return "file"
+	case *ast.BlockStmt:
+		return "block"
+	case *ast.CaseClause, *ast.CommClause:
+		return "case"
+	default:
+		return strings.TrimPrefix(fmt.Sprintf("%T", scope), "*ast.")
+	}
+}
+
 func (f *FileMap) inspectNode(node ast.Node) (bool, error) {
 	if node == nil {
 		return true, nil
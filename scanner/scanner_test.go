@@ -1,6 +1,7 @@
 package scanner_test
 
 import (
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -192,3 +193,166 @@ func test(t *testing.T, tests map[string]string) {
 
 	}
 }
+
+func TestDependents(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	packages := map[string]map[string]string{
+		"a": {"a.go": "package a\n\nfunc A() {}\n"},
+		"b": {"b.go": "package b\n\nimport \"ns/a\"\n\nfunc B() { a.A() }\n"},
+		"c": {
+			"c.go":      "package c\n",
+			"c_test.go": "package c_test\n\nimport (\n\t\"testing\"\n\n\t\"ns/b\"\n)\n\nfunc TestC(t *testing.T) { b.B() }\n",
+		},
+		"d": {"d.go": "package d\n"},
+	}
+	for name, files := range packages {
+		if _, _, err := b.Package(name, files); err != nil {
+			t.Fatalf("Error creating package %s: %+v", name, err)
+		}
+	}
+
+	setup := &shared.Setup{
+		Env:   env,
+		Paths: shared.NewCache(env),
+	}
+	if err := setup.Parse([]string{"ns/..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	cm := scanner.New(setup)
+	if err := cm.LoadGraph(); err != nil {
+		t.Fatalf("Error loading graph: %+v", err)
+	}
+
+	expected := []string{"ns/a", "ns/b", "ns/c"}
+	if got := cm.Dependents([]string{"ns/a"}); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Error in Dependents - got %#v, expected %#v", got, expected)
+	}
+	expected = []string{"ns/d"}
+	if got := cm.Dependents([]string{"ns/d"}); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Error in Dependents - got %#v, expected %#v", got, expected)
+	}
+}
+
+func TestFindFuncs(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	ppath, pdir, err := b.Package("a", map[string]string{
+		"a.go": `package a
+
+type T[K any] struct{}
+
+func (t *T[K]) Foo(i int) int {
+	if i > 0 {
+		return 1
+	} else {
+		return 2
+	}
+}
+
+func Bar(i interface{}) {
+	switch i.(type) {
+	case int:
+	default:
+	}
+}
+`,
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	setup := &shared.Setup{
+		Env:   env,
+		Paths: shared.NewCache(env),
+	}
+	if err := setup.Parse([]string{ppath}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	cm := scanner.New(setup)
+	if err := cm.LoadProgram(); err != nil {
+		t.Fatalf("Error loading program: %+v", err)
+	}
+	if err := cm.ScanPackages(); err != nil {
+		t.Fatalf("Error scanning packages: %+v", err)
+	}
+
+	fpath := filepath.Join(pdir, "a.go")
+	expectedFuncs := []shared.Func{
+		{Name: "(*T).Foo", StartLine: 5, StartCol: 1, EndLine: 11, EndCol: 2},
+		{Name: "Bar", StartLine: 13, StartCol: 1, EndLine: 18, EndCol: 2},
+	}
+	if !reflect.DeepEqual(cm.Funcs[fpath], expectedFuncs) {
+		t.Fatalf("Error in funcs - got:\n%#v\nexpected:\n%#v\n", cm.Funcs[fpath], expectedFuncs)
+	}
+	expectedBranches := []shared.Branch{
+		{Line: 6, Block: 0, Branch: 0, StartLine: 6, StartCol: 11, EndLine: 8, EndCol: 3},
+		{Line: 6, Block: 0, Branch: 1, StartLine: 8, StartCol: 9, EndLine: 10, EndCol: 3},
+		{Line: 14, Block: 1, Branch: 0, StartLine: 15, StartCol: 2, EndLine: 15, EndCol: 11},
+		{Line: 14, Block: 1, Branch: 1, StartLine: 16, StartCol: 2, EndLine: 16, EndCol: 10},
+	}
+	if !reflect.DeepEqual(cm.Branches[fpath], expectedBranches) {
+		t.Fatalf("Error in branches - got:\n%#v\nexpected:\n%#v\n", cm.Branches[fpath], expectedBranches)
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	ppath, pdir, err := b.Package("a", map[string]string{
+		"a.go": `package a
+
+func Foo(i int) int {
+	switch i {
+	case 1:
+		// notest // glue code
+		return 1
+	}
+	if i > 2 {
+		// notestdept
+		return 2
+	}
+	return 3
+}
+`,
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	setup := &shared.Setup{
+		Env:   env,
+		Paths: shared.NewCache(env),
+	}
+	if err := setup.Parse([]string{ppath}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	cm := scanner.New(setup)
+	if err := cm.LoadProgram(); err != nil {
+		t.Fatalf("Error loading program: %+v", err)
+	}
+	if err := cm.ScanPackages(); err != nil {
+		t.Fatalf("Error scanning packages: %+v", err)
+	}
+
+	expected := []shared.Directive{
+		{Type: shared.Notest, Line: 6, EndLine: 7, Text: "// notest // glue code", Scope: "case"},
+		{Type: shared.Notestdept, Line: 10, EndLine: 11, Text: "// notestdept", Scope: "block"},
+	}
+	if got := cm.Directives[filepath.Join(pdir, "a.go")]; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Error in directives - got:\n%#v\nexpected:\n%#v\n", got, expected)
+	}
+}
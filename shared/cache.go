@@ -19,10 +19,12 @@ func NewCache(env vos.Env) *Cache {
 		dirsm:     new(sync.RWMutex),
 		pathm:     new(sync.RWMutex),
 		namem:     new(sync.RWMutex),
+		modm:      new(sync.RWMutex),
 		dirCache:  make(map[keyWithDir]string),
 		dirsCache: make(map[keyWithDir]map[string]string),
 		pathCache: make(map[keyWithDir]string),
 		nameCache: make(map[keyWithDir]string),
+		modCache:  make(map[string]string),
 	}
 }
 
@@ -40,10 +42,12 @@ type Cache struct {
 	dirsm     *sync.RWMutex
 	pathm     *sync.RWMutex
 	namem     *sync.RWMutex
+	modm      *sync.RWMutex
 	dirCache  map[keyWithDir]string
 	dirsCache map[keyWithDir]map[string]string
 	pathCache map[keyWithDir]string
 	nameCache map[keyWithDir]string
+	modCache  map[string]string
 }
 
 // Name does the same as patsy.Name but cached.
@@ -140,6 +144,35 @@ func (c *Cache) FilePath(gpath string) (string, error) {
 	return filepath.Join(fdir, fname), nil
 }
 
+// Register adds the dirs of packages to the cache of the working dir. This
+// is used for packages that the go command can't find from the working dir,
+// e.g. packages of nested modules.
+func (c *Cache) Register(specs []PackageSpec) {
+	for _, spec := range specs {
+		c.setDirs(spec.Path, map[string]string{spec.Path: spec.Dir})
+		c.setDir(spec.Path, spec.Dir)
+		c.setPath(spec.Dir, spec.Path)
+	}
+}
+
+// ModuleDir does the same as ModuleDir but cached.
+func (c *Cache) ModuleDir(dir string) (string, error) {
+	c.modm.RLock()
+	mdir, ok := c.modCache[dir]
+	c.modm.RUnlock()
+	if ok {
+		return mdir, nil
+	}
+	mdir, err := ModuleDir(c.env, dir)
+	if err != nil {
+		return "", err
+	}
+	c.modm.Lock()
+	defer c.modm.Unlock()
+	c.modCache[dir] = mdir
+	return mdir, nil
+}
+
 func (c *Cache) getDir(key string) (string, bool) {
 	c.dirm.RLock()
 	defer c.dirm.RUnlock()
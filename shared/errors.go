@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"errors"
+)
+
+// Exit codes of the gocov command
+const (
+	// ExitOK means that tests passed and coverage requirements were met
+	ExitOK = 0
+	// ExitTestFailure means that 'go test' failed to build or run the tests
+	ExitTestFailure = 1
+	// ExitUsage means that the command line flags or arguments are invalid
+	ExitUsage = 2
+	// ExitCoverage means that coverage requirements were not met
+	ExitCoverage = 3
+	// ExitTool means that a required tool is missing or misconfigured, or any
+	// other unexpected error
+	ExitTool = 4
+)
+
+// TestError is returned when tests fail
+type TestError struct {
+	Err error
+}
+
+func (e *TestError) Error() string { return e.Err.Error() }
+func (e *TestError) Unwrap() error { return e.Err }
+
+// CoverageError is returned when coverage requirements are not met
+type CoverageError struct {
+	Err error
+}
+
+func (e *CoverageError) Error() string { return e.Err.Error() }
+func (e *CoverageError) Unwrap() error { return e.Err }
+
+// UsageError is returned when flags or arguments are invalid
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+func (e *UsageError) Unwrap() error { return e.Err }
+
+// ToolError is returned when an external tool such as 'go' or 'git' is
+// missing or fails unexpectedly
+type ToolError struct {
+	Err error
+}
+
+func (e *ToolError) Error() string { return e.Err.Error() }
+func (e *ToolError) Unwrap() error { return e.Err }
+
+// ExitCode returns the exit code documented for the error. Errors of unknown
+// type map to ExitTool.
+func ExitCode(err error) int {
+	var testErr *TestError
+	var coverageErr *CoverageError
+	var usageErr *UsageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &testErr):
+		return ExitTestFailure
+	case errors.As(err, &coverageErr):
+		return ExitCoverage
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	default:
+		return ExitTool
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/heeus/gocov/shared/vos"
@@ -151,3 +152,102 @@ func Path(env vos.Env, packageDir string) (string, error) {
 
 	return "", errors.Errorf("Package not found for %s", packageDir)
 }
+
+// ModuleDir returns the root dir of the module that contains the directory
+// provided, as reported by the go command. It returns an empty string if the
+// directory is not in a module.
+func ModuleDir(env vos.Env, dir string) (string, error) {
+	exe := exec.Command("go", "env", "GOMOD")
+	exe.Dir = dir
+	exe.Env = env.Environ()
+	out, err := exe.Output()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	gomod := strings.TrimSpace(string(out))
+	if gomod == "" || gomod == os.DevNull {
+		return "", nil
+	}
+	return filepath.Dir(gomod), nil
+}
+
+// Workspace returns the modules of the go.work workspace that contains the
+// working dir. It returns nil if there is no workspace.
+func Workspace(env vos.Env) ([]Module, error) {
+	wd, err := env.Getwd()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	exe := exec.Command("go", "env", "GOWORK")
+	exe.Dir = wd
+	exe.Env = env.Environ()
+	out, err := exe.Output()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	gowork := strings.TrimSpace(string(out))
+	if gowork == "" || gowork == "off" {
+		return nil, nil
+	}
+
+	exe = exec.Command("go", "list", "-m", "-f", "{{.Path}}:{{.Dir}}")
+	exe.Dir = wd
+	exe.Env = env.Environ()
+	out, err = exe.Output()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var modules []Module
+	for _, line := range strings.Split(string(out), "\n") {
+		mpath, dir, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		modules = append(modules, Module{Path: mpath, Dir: dir})
+	}
+	return modules, nil
+}
+
+// NestedModules returns the modules in the working dir and its
+// subdirectories, sorted by dir. Hidden dirs, vendor and
+// testdata dirs are skipped, as the go command ignores them too.
+func NestedModules(env vos.Env) ([]Module, error) {
+	wd, err := env.Getwd()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var modules []Module
+	err = filepath.WalkDir(wd, func(fpath string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if fpath != wd && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		dir := filepath.Dir(fpath)
+		exe := exec.Command("go", "list", "-m", "-f", "{{.Path}}")
+		exe.Dir = dir
+		// list the module itself, not the modules of a go.work workspace
+		exe.Env = append(env.Environ(), "GOWORK=off")
+		out, err := exe.Output()
+		if err != nil {
+			return errors.Wrapf(err, "Error listing module in %s", dir)
+		}
+		modules = append(modules, Module{Path: strings.TrimSpace(string(out)), Dir: dir})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Dir < modules[j].Dir })
+	return modules, nil
+}
@@ -1,10 +1,12 @@
 package shared
 
 import (
-	"errors"
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/heeus/gocov/shared/vos"
+	"github.com/pkg/errors"
 )
 
 type ExcludeType byte
@@ -15,46 +17,198 @@ const (
 	Notestdept
 )
 
+// String returns the name of the comment that causes the exclusion
+func (e ExcludeType) String() string {
+	switch e {
+	case Notest:
+		return "notest"
+	case Notestdept:
+		return "notestdept"
+	default:
+		return ""
+	}
+}
+
+// Func is a function declaration found by the scanner. Methods are named
+// like (T).Name or (*T).Name, as 'go tool cover -func' does.
+type Func struct {
+	Name      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+}
+
+// Branch is one branch of an if, switch or select statement found by the
+// scanner. Start and end enclose the body of the branch.
+type Branch struct {
+	Line      int // line of the statement
+	Block     int // index of the statement in the file
+	Branch    int // index of the branch in the statement
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+}
+
+// Directive is a notest or notestdept comment found by the scanner. Line and
+// EndLine enclose the lines that it excludes.
+type Directive struct {
+	Type    ExcludeType
+	Line    int    // line of the comment
+	EndLine int    // last excluded line
+	Text    string // text of the comment
+	Scope   string // kind of node the exclusion applies to, e.g. "block"
+}
+
+// Output formats
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+	FormatGHA      = "gha"
+	FormatLint     = "lint"
+)
+
+// Path styles of file paths in the output
+const (
+	PathsCwd    = "cwd"
+	PathsModule = "module"
+	PathsAbs    = "abs"
+)
+
+// PathStyles lists the path styles accepted by DisplayPath
+var PathStyles = []string{PathsCwd, PathsModule, PathsAbs}
+
 // Setup holds globals, environment and command line flags for the courtney
 // command
 type Setup struct {
-	Env        vos.Env
-	Paths      *Cache
-	Enforce    bool
-	Verbose    bool
-	Short      bool
-	Notest     bool
-	Notestdept bool
-	Timeout    string
-	Load       string
-	Output     string
-	TestArgs   []string
-	Packages   []PackageSpec
+	Env            vos.Env
+	Paths          *Cache
+	Enforce        bool
+	Verbose        bool
+	Short          bool
+	Notest         bool
+	Notestdept     bool
+	Diff           bool
+	Base           string
+	Threshold      float64
+	Format         string
+	Timeout        string
+	Load           string
+	Output         string
+	Cobertura      string
+	LCOV           string
+	LCOVBranches   bool
+	HTML           string
+	SARIF          string
+	JUnit          string
+	Baseline       string
+	LinkBase       string
+	Func           bool
+	Exclusions     bool
+	Ranges         bool
+	Context        int
+	Sort           string
+	PathStyle      string
+	RecurseModules bool
+	Parallel       int
+	Single         bool
+	Retries        int
+	KeepGoing      bool
+	Builds         []string
+	Script         string
+	TestArgs       []string
+	Args           []string // package arguments, as given on the command line
+	Packages       []PackageSpec
+	Modules        []Module
+}
+
+// TextOutput returns true if the human readable text output is selected
+func (s *Setup) TextOutput() bool {
+	return s.Format == "" || s.Format == FormatText
 }
 
-// PackageSpec identifies a package by dir and path
+// DisplayPath formats a full filepath for output: relative to the working
+// dir with a "./" prefix, relative to the root of its module, or absolute,
+// as set by PathStyle. Files outside of a module are shown relative to the
+// working dir.
+func (s *Setup) DisplayPath(fpath string) string {
+	switch s.PathStyle {
+	case PathsAbs:
+		return fpath
+	case PathsModule:
+		root, err := s.Paths.ModuleDir(filepath.Dir(fpath))
+		if err == nil && root != "" {
+			if rel, err := filepath.Rel(root, fpath); err == nil {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+	wd, err := s.Env.Getwd()
+	if err != nil {
+		// notest
+		return fpath
+	}
+	rel, err := filepath.Rel(wd, fpath)
+	if err != nil {
+		// notest
+		return fpath
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
+
+// PackageSpec identifies a package by dir and path. Module is the dir of
+// its module when modules are tested one by one with RecurseModules.
 type PackageSpec struct {
-	Dir  string
+	Dir    string
+	Path   string
+	Module string
+}
+
+// Module is a module of a go.work workspace
+type Module struct {
 	Path string
+	Dir  string
 }
 
-// Parse parses a slice of strings into the Packages slice
+// Parse parses a slice of strings into the Packages slice. In a go.work
+// workspace the modules are listed in Modules, and relative "/..." patterns
+// are expanded to every workspace module under the pattern's dir, because
+// the go command does not match packages across module boundaries.
 func (s *Setup) Parse(args []string) error {
 
 	if len(args) == 0 {
 		args = []string{"./..."}
 	}
 
+	modules, err := Workspace(s.Env)
+	if err != nil {
+		return &ToolError{Err: errors.Wrap(err, "Error listing workspace modules")}
+	}
+	s.Modules = modules
+
 	packages := map[string]string{}
-	for _, ppath := range args {
-		ppath = strings.TrimSuffix(ppath, "/")
-		paths, err := s.Paths.Dirs(ppath)
+	for _, arg := range args {
+		arg = strings.TrimSuffix(arg, "/")
+		patterns, err := s.expand(arg)
 		if err != nil {
-			return errors.New("Package to test not found")
+			// notest
+			return err
 		}
+		for _, ppath := range patterns {
+			paths, err := s.Paths.Dirs(ppath)
+			if err != nil {
+				return &UsageError{Err: errors.New("Package to test not found")}
+			}
 
-		for importPath, dir := range paths {
-			packages[importPath] = dir
+			for importPath, dir := range paths {
+				packages[importPath] = dir
+			}
 		}
 	}
 
@@ -64,3 +218,82 @@ func (s *Setup) Parse(args []string) error {
 
 	return nil
 }
+
+// expand returns a pattern for every workspace module under the dir of a
+// relative "/..." pattern, and the pattern itself if its dir is a module or
+// is inside of one. Other patterns are returned unchanged.
+func (s *Setup) expand(pattern string) ([]string, error) {
+	if len(s.Modules) == 0 || !strings.HasPrefix(pattern, ".") || !strings.HasSuffix(pattern, "...") {
+		return []string{pattern}, nil
+	}
+	wd, err := s.Env.Getwd()
+	if err != nil {
+		// notest
+		return nil, errors.WithStack(err)
+	}
+	base := filepath.Join(wd, strings.TrimSuffix(pattern, "..."))
+	inside := false
+	var nested []string
+	for _, m := range s.Modules {
+		if within(m.Dir, base) {
+			// the pattern matches the packages of the module that contains
+			// its dir
+			inside = true
+		}
+		if m.Dir == base || !within(base, m.Dir) {
+			continue
+		}
+		dir, err := filepath.Rel(wd, m.Dir)
+		if err != nil {
+			// notest
+			continue
+		}
+		dir = filepath.ToSlash(dir)
+		if !strings.HasPrefix(dir, "../") {
+			dir = "./" + dir
+		}
+		nested = append(nested, dir+"/...")
+	}
+	if inside || len(nested) == 0 {
+		return append([]string{pattern}, nested...), nil
+	}
+	return nested, nil
+}
+
+// within returns true if path is dir or is below it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Undent removes the common indentation of the lines and indents them with a
+// single tab. Empty lines are kept empty, lines of tabs only are undented like
+// the others.
+func Undent(lines []string) []string {
+
+	indentRegex := regexp.MustCompile("[^\t]")
+	mindent := -1
+
+	for _, line := range lines {
+		loc := indentRegex.FindStringIndex(line)
+		if len(loc) == 0 {
+			// notest
+			// string is empty?
+			continue
+		}
+		if mindent == -1 || loc[0] < mindent {
+			mindent = loc[0]
+		}
+	}
+
+	var out []string
+	for _, line := range lines {
+		if line == "" || len(line) < mindent || mindent < 0 {
+			// notest
+			out = append(out, "")
+		} else {
+			out = append(out, "\t"+line[mindent:])
+		}
+	}
+	return out
+}
@@ -2,11 +2,16 @@ package shared_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/heeus/gocov/shared"
 	"github.com/heeus/gocov/shared/builder"
 	"github.com/heeus/gocov/shared/vos"
+	"github.com/pkg/errors"
 )
 
 func TestParseArgs(t *testing.T) {
@@ -119,3 +124,216 @@ func TestParseArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestExitCode(t *testing.T) {
+	base := errors.New("base")
+	tests := map[string]struct {
+		err      error
+		expected int
+	}{
+		"nil":      {nil, shared.ExitOK},
+		"test":     {&shared.TestError{Err: base}, shared.ExitTestFailure},
+		"coverage": {&shared.CoverageError{Err: base}, shared.ExitCoverage},
+		"usage":    {&shared.UsageError{Err: base}, shared.ExitUsage},
+		"tool":     {&shared.ToolError{Err: base}, shared.ExitTool},
+		"unknown":  {base, shared.ExitTool},
+		"wrapped":  {errors.Wrap(&shared.TestError{Err: base}, "Test"), shared.ExitTestFailure},
+	}
+	for name, test := range tests {
+		if code := shared.ExitCode(test.err); code != test.expected {
+			t.Fatalf("Error in %s - got exit code %d, expected %d", name, code, test.expected)
+		}
+	}
+}
+
+func TestDisplayPath(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+	defer b.Cleanup()
+
+	_, adir, err := b.Package("a", map[string]string{
+		"a.go": `package a`,
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+	if err := env.Setwd(adir); err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+	fpath := filepath.Join(adir, "a.go")
+	tests := map[string]string{
+		"":                 "./a.go",
+		shared.PathsCwd:    "./a.go",
+		shared.PathsModule: "a/a.go",
+		shared.PathsAbs:    fpath,
+	}
+	for style, expected := range tests {
+		setup := &shared.Setup{Env: env, Paths: shared.NewCache(env), PathStyle: style}
+		if got := setup.DisplayPath(fpath); got != expected {
+			t.Fatalf("Error in %q - got %s, expected %s", style, got, expected)
+		}
+	}
+	setup := &shared.Setup{Env: env, Paths: shared.NewCache(env)}
+	if got := setup.DisplayPath(filepath.Join(b.Root(), "b.go")); got != "../b.go" {
+		t.Fatalf("Error in parent dir - got %s", got)
+	}
+}
+
+func TestParseWorkspace(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		dir      string          // working dir relative to the root
+		modules  []shared.Module // dirs relative to the root
+		packages []string
+	}{
+		{
+			name: "root is not a module",
+			files: map[string]string{
+				"go.work":      "go 1.21\n\nuse (\n\t./a\n\t./b\n)\n",
+				"a/go.mod":     "module ns/a\n\ngo 1.21\n",
+				"a/a.go":       "package a",
+				"b/go.mod":     "module ns/b\n\ngo 1.21\n",
+				"b/b.go":       "package b",
+				"b/c/c.go":     "package c",
+				"other/foo.go": "package other",
+			},
+			modules:  []shared.Module{{Path: "ns/a", Dir: "a"}, {Path: "ns/b", Dir: "b"}},
+			packages: []string{"ns/a", "ns/b", "ns/b/c"},
+		},
+		{
+			name: "root is a module",
+			files: map[string]string{
+				"go.work":        "go 1.21\n\nuse (\n\t.\n\t./tools\n)\n",
+				"go.mod":         "module ns\n\ngo 1.21\n",
+				"ns.go":          "package ns",
+				"a/a.go":         "package a",
+				"tools/go.mod":   "module ns/tools\n\ngo 1.21\n",
+				"tools/tools.go": "package tools",
+			},
+			modules:  []shared.Module{{Path: "ns", Dir: "."}, {Path: "ns/tools", Dir: "tools"}},
+			packages: []string{"ns", "ns/a", "ns/tools"},
+		},
+		{
+			name: "dir inside of a module with a nested module",
+			files: map[string]string{
+				"go.work":    "go 1.21\n\nuse (\n\t.\n\t./a/x\n)\n",
+				"go.mod":     "module ns\n\ngo 1.21\n",
+				"ns.go":      "package ns",
+				"a/a.go":     "package a",
+				"a/b/b.go":   "package b",
+				"a/x/go.mod": "module ns/a/x\n\ngo 1.21\n",
+				"a/x/x.go":   "package x",
+			},
+			dir:      "a",
+			modules:  []shared.Module{{Path: "ns", Dir: "."}, {Path: "ns/a/x", Dir: "a/x"}},
+			packages: []string{"ns/a", "ns/a/b", "ns/a/x"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := vos.Mock()
+			root, err := os.MkdirTemp("", "ws")
+			if err != nil {
+				t.Fatal(fmt.Sprintf("%+v", err))
+			}
+			defer os.RemoveAll(root)
+			// the dir needs to match what `go list` will be returning
+			if root, err = filepath.EvalSymlinks(root); err != nil {
+				t.Fatal(fmt.Sprintf("%+v", err))
+			}
+			for name, contents := range test.files {
+				fpath := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(fpath), 0777); err != nil {
+					t.Fatal(fmt.Sprintf("%+v", err))
+				}
+				if err := os.WriteFile(fpath, []byte(contents), 0666); err != nil {
+					t.Fatal(fmt.Sprintf("%+v", err))
+				}
+			}
+			if err := env.Setwd(filepath.Join(root, test.dir)); err != nil {
+				t.Fatal(fmt.Sprintf("%+v", err))
+			}
+			env.Setenv("GOWORK", "")
+			env.Setenv("GOFLAGS", "")
+
+			setup := &shared.Setup{Env: env, Paths: shared.NewCache(env)}
+			if err := setup.Parse(nil); err != nil {
+				t.Fatal(fmt.Sprintf("%+v", err))
+			}
+			var expectedModules []shared.Module
+			for _, m := range test.modules {
+				expectedModules = append(expectedModules, shared.Module{Path: m.Path, Dir: filepath.Join(root, m.Dir)})
+			}
+			if !reflect.DeepEqual(setup.Modules, expectedModules) {
+				t.Fatalf("Error in modules - got %#v", setup.Modules)
+			}
+			var packages []string
+			for _, spec := range setup.Packages {
+				packages = append(packages, spec.Path)
+			}
+			sort.Strings(packages)
+			if !reflect.DeepEqual(packages, test.packages) {
+				t.Fatalf("Error in packages - got %v", packages)
+			}
+		})
+	}
+}
+
+func TestNestedModules(t *testing.T) {
+	env := vos.Mock()
+	root, err := os.MkdirTemp("", "nested")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+	defer os.RemoveAll(root)
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+	files := map[string]string{
+		"go.mod":              "module ns\n\ngo 1.21\n",
+		"a/b/go.mod":          "module ns/b\n\ngo 1.21\n",
+		"testdata/c/go.mod":   "module ns/c\n\ngo 1.21\n",
+		".hidden/d/go.mod":    "module ns/d\n\ngo 1.21\n",
+		"vendor/e/go.mod":     "module ns/e\n\ngo 1.21\n",
+		"a/b/inner/f/go.mod":  "module ns/f\n\ngo 1.21\n",
+		"a/b/inner/f/f.go":    "package f",
+		"a/b/inner/notmod.go": "package inner",
+	}
+	for name, contents := range files {
+		fpath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0777); err != nil {
+			t.Fatal(fmt.Sprintf("%+v", err))
+		}
+		if err := os.WriteFile(fpath, []byte(contents), 0666); err != nil {
+			t.Fatal(fmt.Sprintf("%+v", err))
+		}
+	}
+	if err := env.Setwd(root); err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+
+	modules, err := shared.NestedModules(env)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("%+v", err))
+	}
+	expected := []shared.Module{
+		{Path: "ns", Dir: root},
+		{Path: "ns/b", Dir: filepath.Join(root, "a", "b")},
+		{Path: "ns/f", Dir: filepath.Join(root, "a", "b", "inner", "f")},
+	}
+	if !reflect.DeepEqual(modules, expected) {
+		t.Fatalf("Error in modules - got %#v, expected %#v", modules, expected)
+	}
+}
+
+func TestUndent(t *testing.T) {
+	lines := []string{"\t\tif a {", "", "\t\t\t", "\t\t\tb()", "\t\t}"}
+	expected := []string{"\tif a {", "", "\t\t", "\t\tb()", "\t}"}
+	if got := shared.Undent(lines); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Error in Undent - got:\n%#v\nexpected:\n%#v\n", got, expected)
+	}
+}
@@ -0,0 +1,188 @@
+package tester
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/heeus/gocov/shared"
+	"github.com/heeus/gocov/tester/logger"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/cover"
+)
+
+// Integration builds the setup.Builds packages with 'go build -cover', runs
+// the setup.Script with GOCOVERDIR set and merges the coverage the binaries
+// wrote into Results. The binaries are put in a temporary dir at the front of
+// PATH, which is also set as GOCOV_BIN, named by the last element of their
+// import path as 'go build' does. Requires go 1.20 or later.
+func (t *Tester) Integration() error {
+	names, err := t.binaryNames()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "integration")
+	if err != nil {
+		return errors.Wrap(err, "Error creating temporary integration dir")
+	}
+	defer os.RemoveAll(tmp)
+	bin := filepath.Join(tmp, "bin")
+	covdir := filepath.Join(tmp, "cover")
+	for _, dir := range []string{bin, covdir} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			return errors.Wrapf(err, "Error creating %s", dir)
+		}
+	}
+
+	var pkgs []string
+	for _, spec := range t.setup.Packages {
+		pkgs = append(pkgs, spec.Path)
+	}
+	for i, build := range t.setup.Builds {
+		args := []string{"build", "-cover", "-coverpkg=" + strings.Join(pkgs, ",")}
+		if mode := t.coverMode(); mode != "" {
+			args = append(args, "-covermode="+mode)
+		}
+		args = append(args, "-o", filepath.Join(bin, names[i]), build)
+		if err := t.run("go", args, nil, toolError); err != nil {
+			return errors.Wrapf(err, "Error building %s", build)
+		}
+	}
+
+	env := []string{
+		"GOCOVERDIR=" + covdir,
+		"GOCOV_BIN=" + bin,
+		"PATH=" + bin + string(os.PathListSeparator) + t.setup.Env.Getenv("PATH"),
+	}
+	if err := t.run(t.setup.Script, nil, env, testError); err != nil {
+		return errors.Wrapf(err, "Error running %s", t.setup.Script)
+	}
+
+	found, err := isCoverDir(covdir)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &shared.CoverageError{Err: errors.Errorf("Error - %s did not run any of the binaries built with -build", t.setup.Script)}
+	}
+	profiles, err := t.covdata(covdir)
+	if err != nil {
+		return err
+	}
+	return t.addProfiles(profiles)
+}
+
+// binaryNames returns the names of the binaries of the setup.Builds packages.
+// Packages given by dir are named after the dir.
+func (t *Tester) binaryNames() ([]string, error) {
+	wd, err := t.setup.Env.Getwd()
+	if err != nil {
+		// notest
+		return nil, errors.Wrap(err, "Error getting working dir")
+	}
+	var names []string
+	seen := map[string]string{}
+	for _, build := range t.setup.Builds {
+		name := path.Base(build)
+		if build == "." || build == ".." || strings.HasPrefix(build, "./") || strings.HasPrefix(build, "../") {
+			name = filepath.Base(filepath.Join(wd, build))
+		}
+		if other, ok := seen[name]; ok {
+			return nil, &shared.UsageError{Err: errors.Errorf("Error - -build packages %s and %s would both be built as %s", other, build, name)}
+		}
+		seen[name] = build
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// isCoverDir returns true if dir holds the binary coverage data of go 1.20
+// and later, as written to GOCOVERDIR
+func isCoverDir(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error reading %s", dir)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "covmeta.") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// covdata converts the binary coverage data in dir with 'go tool covdata'
+func (t *Tester) covdata(dir string) ([]*cover.Profile, error) {
+	tmp, err := os.MkdirTemp("", "covdata")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating temporary covdata dir")
+	}
+	defer os.RemoveAll(tmp)
+	profile := filepath.Join(tmp, "coverage.out")
+	if err := t.run("go", []string{"tool", "covdata", "textfmt", "-i=" + dir, "-o=" + profile}, nil, toolError); err != nil {
+		return nil, errors.Wrapf(err, "Error converting coverage data in %s", dir)
+	}
+	profiles, err := cover.ParseProfiles(profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing coverage data in %s", dir)
+	}
+	return profiles, nil
+}
+
+// run runs a command in the working dir with extra environment variables.
+// The output is shown with -v, or returned in the error if the command
+// fails. The error of a failing command is made by fail, so that the caller
+// decides whether it is a test failure.
+func (t *Tester) run(name string, args, env []string, fail func(error) error) error {
+	wd, err := t.setup.Env.Getwd()
+	if err != nil {
+		// notest
+		return errors.Wrap(err, "Error getting working dir")
+	}
+	combined, stdout, stderr := logger.Log(
+		t.setup.Verbose,
+		t.setup.Env.Stdout(),
+		t.setup.Env.Stderr(),
+	)
+	if t.setup.Verbose {
+		fmt.Fprintf(t.setup.Env.Stdout(), "Running: %s\n", strings.Join(append([]string{name}, args...), " "))
+	}
+	exe := exec.Command(name, args...)
+	exe.Dir = wd
+	exe.Env = append(t.setup.Env.Environ(), env...)
+	exe.Stdout = stdout
+	exe.Stderr = stderr
+	if err := exe.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return &shared.ToolError{Err: errors.WithStack(err)}
+		}
+		if t.setup.Verbose {
+			return fail(errors.WithStack(err))
+		}
+		return fail(errors.Wrapf(err, "Output:[\n%s]\n", combined.String()))
+	}
+	return nil
+}
+
+func testError(err error) error { return &shared.TestError{Err: err} }
+func toolError(err error) error { return &shared.ToolError{Err: err} }
+
+// coverMode returns the -covermode passed to 'go test', so that the profiles
+// of the binaries can be merged with the profiles of the tests
+func (t *Tester) coverMode() string {
+	args := t.setup.TestArgs
+	for i, arg := range args {
+		arg = "-" + strings.TrimLeft(arg, "-")
+		if mode, ok := strings.CutPrefix(arg, "-covermode="); ok {
+			return mode
+		}
+		if arg == "-covermode" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
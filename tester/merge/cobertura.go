@@ -0,0 +1,166 @@
+package merge
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/cover"
+)
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// DumpCobertura writes a slice of profiles to a writer as a Cobertura XML
+// report. Files are grouped into packages by their import path. The
+// filenames map converts profile filenames to paths relative to source;
+// filenames missing from the map are written unchanged. Only lines that are
+// part of a block are listed, so blocks removed from the profiles are left
+// out of the totals. The line-rate attributes are computed from statements,
+// like the total of gocov, while lines-covered and lines-valid count lines.
+func DumpCobertura(profiles []*cover.Profile, source string, filenames map[string]string, out io.Writer) error {
+	c := coberturaCoverage{
+		BranchRate: "0",
+		Complexity: "0",
+		Timestamp:  time.Now().Unix(),
+		Sources:    []string{source},
+	}
+	packages := map[string]*coberturaPackage{}
+	var names []string
+	// covered and total statements per package
+	counts := map[string][2]int{}
+	var covered, statements int
+	for _, p := range profiles {
+		pkgName := path.Dir(p.FileName)
+		pkg, ok := packages[pkgName]
+		if !ok {
+			pkg = &coberturaPackage{Name: pkgName, BranchRate: "0", Complexity: "0"}
+			packages[pkgName] = pkg
+			names = append(names, pkgName)
+		}
+		filename, ok := filenames[p.FileName]
+		if !ok {
+			filename = p.FileName
+		}
+		class := coberturaClass{
+			Name:       path.Base(p.FileName),
+			Filename:   filename,
+			BranchRate: "0",
+			Complexity: "0",
+		}
+		for _, lh := range LineHits(p) {
+			class.Lines = append(class.Lines, coberturaLine{Number: lh.Line, Hits: lh.Hits})
+			if lh.Hits > 0 {
+				c.LinesCovered++
+			}
+		}
+		c.LinesValid += len(class.Lines)
+		stmtsCovered, stmts := 0, 0
+		for _, b := range p.Blocks {
+			stmts += b.NumStmt
+			if b.Count > 0 {
+				stmtsCovered += b.NumStmt
+			}
+		}
+		class.LineRate = rate(stmtsCovered, stmts)
+		pkg.Classes = append(pkg.Classes, class)
+
+		n := counts[pkgName]
+		counts[pkgName] = [2]int{n[0] + stmtsCovered, n[1] + stmts}
+		covered += stmtsCovered
+		statements += stmts
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pkg := packages[name]
+		pkg.LineRate = rate(counts[name][0], counts[name][1])
+		c.Packages = append(c.Packages, *pkg)
+	}
+	c.LineRate = rate(covered, statements)
+
+	fmt.Fprint(out, xml.Header)
+	fmt.Fprintln(out, `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`)
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "\t")
+	if err := enc.Encode(c); err != nil {
+		return errors.Wrap(err, "Error writing Cobertura report")
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+// LineHit is the hit count of a single line
+type LineHit struct {
+	Line int
+	Hits int
+}
+
+// LineHits returns the sorted hit counts of the lines that are part of a
+// block with statements. A line shared by several blocks gets the lowest
+// count, so a line is only hit if all of its code ran.
+func LineHits(p *cover.Profile) []LineHit {
+	hits := map[int]int{}
+	for _, b := range p.Blocks {
+		if b.NumStmt == 0 {
+			continue
+		}
+		for line := b.StartLine; line <= b.EndLine; line++ {
+			if h, ok := hits[line]; !ok || b.Count < h {
+				hits[line] = b.Count
+			}
+		}
+	}
+	var out []LineHit
+	for line, h := range hits {
+		out = append(out, LineHit{Line: line, Hits: h})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Line < out[j].Line })
+	return out
+}
+
+func rate(covered, valid int) string {
+	if valid == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(valid), 'f', 4, 64)
+}
@@ -0,0 +1,71 @@
+package merge_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/heeus/gocov/tester/merge"
+	"golang.org/x/tools/cover"
+)
+
+func TestLineHits(t *testing.T) {
+	p := &cover.Profile{
+		FileName: "ns/a/a.go",
+		Mode:     "count",
+		Blocks: []cover.ProfileBlock{
+			{StartLine: 3, EndLine: 5, NumStmt: 2, Count: 4},
+			{StartLine: 5, EndLine: 6, NumStmt: 1, Count: 0},
+			{StartLine: 8, EndLine: 9, NumStmt: 0, Count: 0},
+		},
+	}
+	expected := []merge.LineHit{
+		{Line: 3, Hits: 4},
+		{Line: 4, Hits: 4},
+		{Line: 5, Hits: 0},
+		{Line: 6, Hits: 0},
+	}
+	if got := merge.LineHits(p); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Error in LineHits - got:\n%#v\nexpected:\n%#v\n", got, expected)
+	}
+}
+
+func TestDumpCobertura(t *testing.T) {
+	profiles := []*cover.Profile{
+		{
+			FileName: "ns/a/a.go",
+			Mode:     "set",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 3, EndLine: 4, NumStmt: 2, Count: 1},
+				{StartLine: 6, EndLine: 7, NumStmt: 1, Count: 0},
+			},
+		},
+		{
+			FileName: "ns/b/b.go",
+			Mode:     "set",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 1, EndLine: 1, NumStmt: 1, Count: 1},
+			},
+		},
+	}
+	out := &bytes.Buffer{}
+	err := merge.DumpCobertura(profiles, "/src", map[string]string{"ns/a/a.go": "a/a.go"}, out)
+	if err != nil {
+		t.Fatalf("Error dumping Cobertura: %s", err)
+	}
+	for _, expected := range []string{
+		// rates count statements, like the total of gocov: 3 of 4 are covered
+		`<coverage line-rate="0.7500" branch-rate="0" lines-covered="3" lines-valid="5"`,
+		`<source>/src</source>`,
+		`<package name="ns/a" line-rate="0.6667"`,
+		`<class name="a.go" filename="a/a.go" line-rate="0.6667"`,
+		`<line number="6" hits="0"></line>`,
+		`<package name="ns/b" line-rate="1.0000"`,
+		`<class name="b.go" filename="ns/b/b.go" line-rate="1.0000"`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("Error in Cobertura report - expected to contain:\n%s\ngot:\n%s\n", expected, out.String())
+		}
+	}
+}
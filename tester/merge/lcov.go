@@ -0,0 +1,132 @@
+package merge
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/heeus/gocov/shared"
+	"golang.org/x/tools/cover"
+)
+
+// DumpLCOV writes a slice of profiles to a writer as an LCOV tracefile. The
+// filenames map converts profile filenames to the paths written to SF
+// records; filenames missing from the map are written unchanged. The
+// optional funcs and branches, keyed by profile filename, add FN/FNDA and
+// BRDA records. Functions and branches without any blocks, e.g. because all
+// of their code was excluded, are left out. The taken count of a branch whose
+// statement was never reached is written as "-", as the LCOV format requires.
+func DumpLCOV(profiles []*cover.Profile, filenames map[string]string, funcs map[string][]shared.Func, branches map[string][]shared.Branch, out io.Writer) {
+	for _, p := range profiles {
+		filename, ok := filenames[p.FileName]
+		if !ok {
+			filename = p.FileName
+		}
+		fmt.Fprintln(out, "TN:")
+		fmt.Fprintf(out, "SF:%s\n", filename)
+
+		var fnda []string
+		fnh := 0
+		for _, fn := range funcs[p.FileName] {
+			// the entry block holds the statements of the function up to its
+			// first branch, so it runs on every call: its count is the number
+			// of calls in count and atomic mode, and 1 if it was called at
+			// all in set mode
+			b, ok := entryBlock(p.Blocks, fn.StartLine, fn.StartCol, fn.EndLine, fn.EndCol)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(out, "FN:%d,%s\n", fn.StartLine, fn.Name)
+			fnda = append(fnda, fmt.Sprintf("FNDA:%d,%s", b.Count, fn.Name))
+			if b.Count > 0 {
+				fnh++
+			}
+		}
+		for _, s := range fnda {
+			fmt.Fprintln(out, s)
+		}
+		fmt.Fprintf(out, "FNF:%d\nFNH:%d\n", len(fnda), fnh)
+
+		if branches != nil {
+			brf, brh := 0, 0
+			// a statement was reached if any of its branches was taken
+			taken := map[int]bool{}
+			for _, br := range branches[p.FileName] {
+				if b, ok := entryBlock(p.Blocks, br.StartLine, br.StartCol, br.EndLine, br.EndCol); ok && b.Count > 0 {
+					taken[br.Block] = true
+				}
+			}
+			for _, br := range branches[p.FileName] {
+				b, ok := entryBlock(p.Blocks, br.StartLine, br.StartCol, br.EndLine, br.EndCol)
+				if !ok {
+					continue
+				}
+				count := fmt.Sprint(b.Count)
+				if !taken[br.Block] && !reached(p.Blocks, br) {
+					count = "-"
+				}
+				fmt.Fprintf(out, "BRDA:%d,%d,%d,%s\n", br.Line, br.Block, br.Branch, count)
+				brf++
+				if b.Count > 0 {
+					brh++
+				}
+			}
+			fmt.Fprintf(out, "BRF:%d\nBRH:%d\n", brf, brh)
+		}
+
+		lh := 0
+		hits := LineHits(p)
+		for _, h := range hits {
+			fmt.Fprintf(out, "DA:%d,%d\n", h.Line, h.Hits)
+			if h.Hits > 0 {
+				lh++
+			}
+		}
+		fmt.Fprintf(out, "LF:%d\nLH:%d\n", len(hits), lh)
+		fmt.Fprintln(out, "end_of_record")
+	}
+}
+
+// entryBlock returns the block with statements that starts first within the
+// provided positions, which is the block that runs whenever the enclosed code
+// is entered
+func entryBlock(blocks []cover.ProfileBlock, startLine, startCol, endLine, endCol int) (cover.ProfileBlock, bool) {
+	var entry cover.ProfileBlock
+	found := false
+	for _, b := range blocks {
+		if b.NumStmt == 0 {
+			continue
+		}
+		if b.StartLine < startLine || b.StartLine == startLine && b.StartCol < startCol {
+			continue
+		}
+		if b.EndLine > endLine || b.EndLine == endLine && b.EndCol > endCol {
+			continue
+		}
+		if !found || b.StartLine < entry.StartLine || b.StartLine == entry.StartLine && b.StartCol < entry.StartCol {
+			entry = b
+			found = true
+		}
+	}
+	return entry, found
+}
+
+// reached returns true if the statement of a branch ran, as told by the block
+// that ends at the statement's header, before the body of the branch. Without
+// such a block, e.g. because it was excluded, the statement counts as reached.
+func reached(blocks []cover.ProfileBlock, br shared.Branch) bool {
+	var header cover.ProfileBlock
+	found := false
+	for _, b := range blocks {
+		if b.NumStmt == 0 || b.EndLine < br.Line {
+			continue
+		}
+		if b.EndLine > br.StartLine || b.EndLine == br.StartLine && b.EndCol > br.StartCol {
+			continue
+		}
+		if !found || b.EndLine > header.EndLine || b.EndLine == header.EndLine && b.EndCol > header.EndCol {
+			header = b
+			found = true
+		}
+	}
+	return !found || header.Count > 0
+}
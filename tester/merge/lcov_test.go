@@ -0,0 +1,92 @@
+package merge_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/heeus/gocov/shared"
+	"github.com/heeus/gocov/tester/merge"
+	"golang.org/x/tools/cover"
+)
+
+func TestDumpLCOV(t *testing.T) {
+	profiles := []*cover.Profile{
+		{
+			FileName: "ns/a/a.go",
+			Mode:     "count",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 3, StartCol: 21, EndLine: 4, EndCol: 6, NumStmt: 1, Count: 2},
+				{StartLine: 4, StartCol: 6, EndLine: 6, EndCol: 3, NumStmt: 1, Count: 0},
+				{StartLine: 6, StartCol: 3, EndLine: 7, EndCol: 2, NumStmt: 1, Count: 2},
+			},
+		},
+	}
+	funcs := map[string][]shared.Func{
+		"ns/a/a.go": {
+			{Name: "Foo", StartLine: 3, StartCol: 1, EndLine: 8, EndCol: 2},
+			{Name: "(*T).Excluded", StartLine: 10, StartCol: 1, EndLine: 12, EndCol: 2},
+		},
+	}
+	branches := map[string][]shared.Branch{
+		"ns/a/a.go": {
+			{Line: 4, Block: 0, Branch: 0, StartLine: 4, StartCol: 6, EndLine: 6, EndCol: 3},
+			{Line: 4, Block: 0, Branch: 1, StartLine: 6, StartCol: 3, EndLine: 7, EndCol: 2},
+		},
+	}
+	expected := `TN:
+SF:a/a.go
+FN:3,Foo
+FNDA:2,Foo
+FNF:1
+FNH:1
+BRDA:4,0,0,0
+BRDA:4,0,1,2
+BRF:2
+BRH:1
+DA:3,2
+DA:4,0
+DA:5,0
+DA:6,0
+DA:7,2
+LF:5
+LH:2
+end_of_record
+`
+	out := &bytes.Buffer{}
+	merge.DumpLCOV(profiles, map[string]string{"ns/a/a.go": "a/a.go"}, funcs, branches, out)
+	if out.String() != expected {
+		t.Fatalf("Error in LCOV - got:\n%s\nexpected:\n%s\n", out.String(), expected)
+	}
+
+	// without branches
+	out.Reset()
+	merge.DumpLCOV(profiles, nil, nil, nil, out)
+	if bytes.Contains(out.Bytes(), []byte("BRDA")) || !bytes.Contains(out.Bytes(), []byte("SF:ns/a/a.go\n")) {
+		t.Fatalf("Error in LCOV without branches - got:\n%s\n", out.String())
+	}
+
+	// a function that was never called, its if statement was never reached
+	profiles = []*cover.Profile{
+		{
+			FileName: "ns/a/b.go",
+			Mode:     "set",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 3, StartCol: 16, EndLine: 4, EndCol: 11, NumStmt: 2, Count: 0},
+				{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1, Count: 0},
+			},
+		},
+	}
+	funcs = map[string][]shared.Func{
+		"ns/a/b.go": {{Name: "Bar", StartLine: 3, StartCol: 1, EndLine: 7, EndCol: 2}},
+	}
+	branches = map[string][]shared.Branch{
+		"ns/a/b.go": {{Line: 4, Block: 0, Branch: 0, StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3}},
+	}
+	out.Reset()
+	merge.DumpLCOV(profiles, nil, funcs, branches, out)
+	for _, expected := range []string{"FNDA:0,Bar\n", "FNH:0\n", "BRDA:4,0,0,-\n", "BRH:0\n"} {
+		if !bytes.Contains(out.Bytes(), []byte(expected)) {
+			t.Fatalf("Error in LCOV of an unreached branch - expected to contain %q, got:\n%s\n", expected, out.String())
+		}
+	}
+}
@@ -3,16 +3,22 @@ package tester
 import (
 	"crypto/md5"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/heeus/gocov/shared"
 	"github.com/heeus/gocov/tester/logger"
 	"github.com/heeus/gocov/tester/merge"
+	"github.com/heeus/gocov/tester/testjson"
 	"github.com/pkg/errors"
 	"golang.org/x/tools/cover"
 )
@@ -34,19 +40,67 @@ func New(setup *shared.Setup) *Tester {
 type Tester struct {
 	setup             *shared.Setup
 	cover             string
+	profiles          map[string][]*cover.Profile
 	Results           []*cover.Profile
+	Excluded          map[shared.ExcludeType][]*cover.Profile
+	Failures          []Failure
+	Flaky             []Flaky
+	Tests             []*testjson.Package
 	notestResults     []*cover.Profile
 	notestdeptResults []*cover.Profile
+	progress          *testjson.Progress
+
+	// mu guards the results and outm the output when packages are tested in
+	// parallel
+	mu   sync.Mutex
+	outm sync.Mutex
+}
+
+// Flaky records a test that failed and passed when it was run again
+type Flaky struct {
+	Package  string
+	Test     string
+	Attempts int // runs until it passed, including the first
+}
+
+// Failure records a package whose tests failed
+type Failure struct {
+	Package string
+	Output  string
 }
 
-// Load loads pre-prepared coverage files instead of running 'go test'
+// Load loads pre-prepared coverage files instead of running 'go test'. The
+// pattern can match coverage profiles, and dirs with the binary coverage data
+// of go 1.20 and later, as written to GOCOVERDIR.
 func (t *Tester) Load() error {
 	files, err := filepath.Glob(t.setup.Load)
 	if err != nil {
-		return errors.Wrap(err, "Error loading coverage files")
+		return &shared.UsageError{Err: errors.Wrap(err, "Error loading coverage files")}
 	}
 	for _, fpath := range files {
-		if err := t.processCoverageFile(fpath); err != nil {
+		info, err := os.Stat(fpath)
+		if err != nil {
+			// notest
+			return errors.Wrapf(err, "Error loading %s", fpath)
+		}
+		if !info.IsDir() {
+			if err := t.processCoverageFile(fpath); err != nil {
+				return err
+			}
+			continue
+		}
+		found, err := isCoverDir(fpath)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return &shared.UsageError{Err: errors.Errorf("Error - %s has no coverage data", fpath)}
+		}
+		profiles, err := t.covdata(fpath)
+		if err != nil {
+			return err
+		}
+		if err := t.addProfiles(profiles); err != nil {
 			return err
 		}
 	}
@@ -61,12 +115,159 @@ func (t *Tester) Test() error {
 		return errors.Wrap(err, "Error creating temporary coverage dir")
 	}
 	defer os.RemoveAll(t.cover)
-	for _, spec := range t.setup.Packages {
-		if err := t.processDir(spec.Dir); err != nil {
+	stop := t.startProgress(len(t.setup.Packages))
+	err = t.test()
+	stop()
+	if t.setup.KeepGoing {
+		err = t.failures(err)
+	}
+	return t.summarize(err)
+}
+
+func (t *Tester) test() error {
+	if t.setup.Single {
+		ok, err := t.singleSupported()
+		if err != nil {
 			return err
 		}
+		if ok {
+			return t.processAll()
+		}
+		fmt.Fprintln(t.setup.Env.Stderr(), "go 1.20 or later is needed to test all packages at once, testing each dir instead")
 	}
-	return nil
+	return t.processDirs(t.setup.Packages, t.setup.KeepGoing)
+}
+
+// failures replaces a test error with one that lists every failed package,
+// as with KeepGoing the tests of all packages are run
+func (t *Tester) failures(err error) error {
+	var testErr *shared.TestError
+	if err == nil || !errors.As(err, &testErr) || len(t.Failures) == 0 {
+		return err
+	}
+	var failed []string
+	for _, f := range t.Failures {
+		failed = append(failed, f.Package)
+	}
+	sort.Strings(failed)
+	return &shared.TestError{Err: errors.Errorf("Error executing tests of %d of %d packages: %s",
+		len(failed), len(t.setup.Packages), strings.Join(failed, ", "))}
+}
+
+// startProgress shows a live progress line on stderr until the returned
+// function is called. It is only shown on a terminal and without -v.
+func (t *Tester) startProgress(packages int) (stop func()) {
+	t.progress = nil
+	if t.setup.Verbose || !isTerminal(t.setup.Env.Stderr()) {
+		return func() {}
+	}
+	t.progress = testjson.NewProgress(packages, time.Now())
+	w := t.setup.Env.Stderr()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// \x1b[K clears the rest of the line
+				fmt.Fprintf(w, "\r%s\x1b[K", t.progress.Line(time.Now()))
+			case <-done:
+				fmt.Fprintf(w, "\r%s\x1b[K\n", t.progress.Line(time.Now()))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// summarize prints the failed tests and their output after tests failed,
+// unless the output was shown with -v already
+func (t *Tester) summarize(err error) error {
+	var testErr *shared.TestError
+	if err == nil || t.setup.Verbose || !errors.As(err, &testErr) {
+		return err
+	}
+	out := t.setup.Env.Stdout()
+	if !t.setup.TextOutput() {
+		out = t.setup.Env.Stderr()
+	}
+	fmt.Fprintln(out, "------------------------------\t\n"+
+		"The following tests failed:\t\n"+
+		"------------------------------")
+	if werr := testjson.WriteFailures(out, t.Tests); werr != nil {
+		// notest
+		return werr
+	}
+	return err
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Retest reruns the tests of the provided packages and rebuilds Results from
+// the coverage of every package tested so far. Coverage of the files in the
+// changed packages is only taken from the fresh runs, because block positions
+// recorded before the change may be out of date. All packages are run even if
+// some fail, and the first failure is returned. Tests, Flaky and Failures only
+// hold the tests of this run.
+func (t *Tester) Retest(specs []shared.PackageSpec, changed []string) error {
+	var err error
+	if t.cover, err = ioutil.TempDir("", "coverage"); err != nil {
+		return errors.Wrap(err, "Error creating temporary coverage dir")
+	}
+	defer os.RemoveAll(t.cover)
+
+	rerun := map[string]bool{}
+	for _, spec := range specs {
+		rerun[spec.Dir] = true
+	}
+	stale := map[string]bool{}
+	for _, ppath := range changed {
+		stale[ppath] = true
+	}
+	for dir, profiles := range t.profiles {
+		if rerun[dir] {
+			delete(t.profiles, dir)
+			continue
+		}
+		var kept []*cover.Profile
+		for _, p := range profiles {
+			if !stale[path.Dir(p.FileName)] {
+				kept = append(kept, p)
+			}
+		}
+		t.profiles[dir] = kept
+	}
+
+	t.Results = nil
+	t.Tests = nil
+	t.Flaky = nil
+	t.Failures = nil
+	stop := t.startProgress(len(specs))
+	first := t.processDirs(specs, true)
+	stop()
+	for dir, profiles := range t.profiles {
+		if rerun[dir] {
+			// already merged by processDir
+			continue
+		}
+		if err := t.addProfiles(profiles); err != nil {
+			return err
+		}
+	}
+	return t.summarize(first)
 }
 
 func (t *Tester) doSave(extype shared.ExcludeType, outf string) error {
@@ -82,7 +283,9 @@ func (t *Tester) doSave(extype shared.ExcludeType, outf string) error {
 	}
 
 	if len(rs) == 0 {
-		fmt.Fprintln(t.setup.Env.Stdout(), "No results")
+		if t.setup.TextOutput() {
+			fmt.Fprintln(t.setup.Env.Stdout(), "No results")
+		}
 		return nil
 	}
 	currentDir, err := t.setup.Env.Getwd()
@@ -107,6 +310,87 @@ func (t *Tester) Save() error {
 	return t.doSave(shared.Notestall, CoverageFileName)
 }
 
+// SaveCobertura saves the results as a Cobertura XML report to the file set
+// in setup.Cobertura. Filenames are relative to the working dir.
+func (t *Tester) SaveCobertura() error {
+	currentDir, err := t.setup.Env.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "Error getting working dir")
+	}
+	filenames, _, err := t.filenames(currentDir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(t.setup.Cobertura)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating Cobertura report %s", t.setup.Cobertura)
+	}
+	defer f.Close()
+	return merge.DumpCobertura(t.Results, currentDir, filenames, f)
+}
+
+// SaveJUnit saves the test results as a JUnit XML report to the file set in
+// setup.JUnit
+func (t *Tester) SaveJUnit() error {
+	f, err := os.Create(t.setup.JUnit)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating JUnit report %s", t.setup.JUnit)
+	}
+	defer f.Close()
+	return testjson.DumpJUnit(t.Tests, f)
+}
+
+// SaveLCOV saves the results as an LCOV tracefile to the file set in
+// setup.LCOV. funcs and branches are keyed by full filepath, as found by the
+// scanner. Branch records are only written if setup.LCOVBranches is set.
+func (t *Tester) SaveLCOV(funcs map[string][]shared.Func, branches map[string][]shared.Branch) error {
+	currentDir, err := t.setup.Env.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "Error getting working dir")
+	}
+	filenames, fpaths, err := t.filenames(currentDir)
+	if err != nil {
+		return err
+	}
+	profileFuncs := make(map[string][]shared.Func, len(fpaths))
+	var profileBranches map[string][]shared.Branch
+	if t.setup.LCOVBranches {
+		profileBranches = make(map[string][]shared.Branch, len(fpaths))
+	}
+	for name, fpath := range fpaths {
+		profileFuncs[name] = funcs[fpath]
+		if profileBranches != nil {
+			profileBranches[name] = branches[fpath]
+		}
+	}
+	f, err := os.Create(t.setup.LCOV)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating LCOV tracefile %s", t.setup.LCOV)
+	}
+	defer f.Close()
+	merge.DumpLCOV(t.Results, filenames, profileFuncs, profileBranches, f)
+	return nil
+}
+
+// filenames maps the filenames of the results to paths relative to dir, and
+// to full filepaths
+func (t *Tester) filenames(dir string) (relative map[string]string, full map[string]string, err error) {
+	relative = make(map[string]string, len(t.Results))
+	full = make(map[string]string, len(t.Results))
+	for _, p := range t.Results {
+		fpath, err := t.setup.Paths.FilePath(p.FileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		full[p.FileName] = fpath
+		if rel, err := filepath.Rel(dir, fpath); err == nil {
+			fpath = filepath.ToSlash(rel)
+		}
+		relative[p.FileName] = fpath
+	}
+	return relative, full, nil
+}
+
 // SaveUn saves the uncoverage file
 func (t *Tester) SaveUn(extype shared.ExcludeType) error {
 	return t.doSave(extype, UncoverageFileName)
@@ -154,16 +438,16 @@ func (t *Tester) Enforce() error {
 		lines := strings.Split(string(by), "\n")
 		for _, b := range blocks {
 			s += fmt.Sprintf("%s:%d-%d:\n", name, b.StartLine, b.EndLine)
-			undented := undent(lines[b.StartLine-1 : b.EndLine])
+			undented := shared.Undent(lines[b.StartLine-1 : b.EndLine])
 			s += strings.Join(undented, "\n")
 		}
 	}
-	return errors.Errorf("Error - untested code:\n%s", s)
+	return &shared.CoverageError{Err: errors.Errorf("Error - untested code:\n%s", s)}
 
 }
 
 // ProcessExcludes uses the output from the scanner package and removes blocks
-// from the merged coverage file.
+// from the merged coverage file. The removed blocks are kept in Excluded.
 func (t *Tester) ProcessExcludes(excludes map[string]map[int]shared.ExcludeType) error {
 	var notestexclud []*cover.Profile
 	var notestdeptexclud []*cover.Profile
@@ -195,6 +479,7 @@ func (t *Tester) ProcessExcludes(excludes map[string]map[int]shared.ExcludeType)
 		notestdeptexclud = append(notestdeptexclud, notestdeptprofile)
 	}
 
+	excluded := make(map[shared.ExcludeType][]*cover.Profile)
 	var p *cover.Profile
 	for _, p = range t.Results {
 
@@ -246,15 +531,78 @@ func (t *Tester) ProcessExcludes(excludes map[string]map[int]shared.ExcludeType)
 			Blocks:   blocks,
 		}
 		processed = append(processed, profile)
+		if len(notestblocks) > 0 {
+			excluded[shared.Notest] = append(excluded[shared.Notest], &cover.Profile{
+				FileName: p.FileName,
+				Mode:     p.Mode,
+				Blocks:   notestblocks,
+			})
+		}
+		if len(notestdeptblocks) > 0 {
+			excluded[shared.Notestdept] = append(excluded[shared.Notestdept], &cover.Profile{
+				FileName: p.FileName,
+				Mode:     p.Mode,
+				Blocks:   notestdeptblocks,
+			})
+		}
 	}
 
 	t.Results = processed
+	t.Excluded = excluded
 	t.notestResults = notestexclud
 	t.notestdeptResults = notestdeptexclud
 	return nil
 }
 
-func (t *Tester) processDir(dir string) error {
+// processDirs tests the packages, up to setup.Parallel at a time. Unless all
+// is set no more packages are started after a failure. The error of the
+// first failed package in specs order is returned.
+func (t *Tester) processDirs(specs []shared.PackageSpec, all bool) error {
+	parallel := t.setup.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	errs := make([]error, len(specs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var failed bool
+	for i, spec := range specs {
+		sem <- struct{}{}
+		t.mu.Lock()
+		stop := failed && !all
+		t.mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, spec shared.PackageSpec) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if errs[i] = t.processDir(spec); errs[i] != nil {
+				t.mu.Lock()
+				failed = true
+				t.mu.Unlock()
+			}
+		}(i, spec)
+	}
+	wg.Wait()
+	// failed tests are reported after errors that stopped the go command
+	var first error
+	for _, err := range errs {
+		var testErr *shared.TestError
+		if err != nil && !errors.As(err, &testErr) {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t *Tester) processDir(spec shared.PackageSpec) error {
+	dir := spec.Dir
 
 	coverfile := filepath.Join(
 		t.cover,
@@ -274,19 +622,227 @@ func (t *Tester) processDir(dir string) error {
 	}
 	if !foundTest {
 		// notest
+		if t.progress != nil {
+			t.progress.Add(testjson.Event{Action: testjson.ActionSkip, Package: spec.Path})
+		}
 		return nil
 	}
-	combined, _, stderr := logger.Log(
-		t.setup.Verbose,
+	var pkgs []string
+	for _, s := range t.setup.Packages {
+		// packages of other nested modules can't be built from this one
+		if s.Module == spec.Module {
+			pkgs = append(pkgs, s.Path)
+		}
+	}
+	out, results, err := t.goTest(dir, nil, pkgs, coverfile)
+	coverfiles := []string{coverfile}
+	var flaky []Flaky
+	if err != nil && len(results) > 0 {
+		if retryfile, f, ok := t.retry(dir, nil, pkgs, results[0], coverfile); ok {
+			err = nil
+			coverfiles = append(written(coverfile), retryfile)
+			flaky = f
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Tests = append(t.Tests, results...)
+	t.Flaky = append(t.Flaky, flaky...)
+	if strings.Contains(out, "no buildable Go source files in") {
+		// notest
+		return nil
+	}
+
+	if err != nil {
+		// TODO: Remove when https://github.com/dave/courtney/issues/4 is fixed
+		// notest
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			// the go command could not be started at all
+			return &shared.ToolError{Err: errors.Wrap(err, "Error executing go")}
+		}
+		t.markFailed(spec.Path, out)
+		t.Failures = append(t.Failures, Failure{Package: spec.Path, Output: out})
+		return t.testError(err)
+	}
+	return t.addCoverFiles(dir, coverfiles...)
+}
+
+// processAll runs 'go test' once for all packages of each module, which
+// produces a single merged profile in go 1.20 and later.
+func (t *Tester) processAll() error {
+	wd, err := t.setup.Env.Getwd()
+	if err != nil {
+		// notest
+		return errors.Wrap(err, "Error getting working dir")
+	}
+	var modules []string
+	byModule := map[string][]string{}
+	for _, spec := range t.setup.Packages {
+		if _, ok := byModule[spec.Module]; !ok {
+			modules = append(modules, spec.Module)
+		}
+		byModule[spec.Module] = append(byModule[spec.Module], spec.Path)
+	}
+	var first error
+	for _, module := range modules {
+		dir := module
+		if dir == "" {
+			dir = wd
+		}
+		pkgs := byModule[module]
+		coverfile := filepath.Join(
+			t.cover,
+			fmt.Sprintf("%x", md5.Sum([]byte(dir)))+".out",
+		)
+		out, results, err := t.goTest(dir, pkgs, pkgs, coverfile)
+		t.Tests = append(t.Tests, results...)
+		coverfiles := []string{coverfile}
+		if err != nil && t.setup.Retries > 0 {
+			// retry each failed package on its own, the tests are only
+			// flaky if every package passes
+			var retryfiles []string
+			var flaky []Flaky
+			ok := false
+			for i, pkg := range results {
+				if pkg.Action != testjson.ActionFail {
+					continue
+				}
+				var retryfile string
+				var f []Flaky
+				if retryfile, f, ok = t.retry(dir, []string{pkg.Path}, pkgs, pkg, fmt.Sprintf("%s.%d", coverfile, i)); !ok {
+					break
+				}
+				retryfiles = append(retryfiles, retryfile)
+				flaky = append(flaky, f...)
+			}
+			if ok {
+				err = nil
+				coverfiles = append(written(coverfile), retryfiles...)
+				t.Flaky = append(t.Flaky, flaky...)
+			}
+		}
+		if err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				// notest
+				return &shared.ToolError{Err: errors.Wrap(err, "Error executing go")}
+			}
+			failed := false
+			for _, pkg := range results {
+				if pkg.Action == testjson.ActionFail {
+					failed = true
+					t.Failures = append(t.Failures, Failure{Package: pkg.Path, Output: pkg.Text()})
+				}
+			}
+			if !failed {
+				// e.g. the packages could not be listed
+				t.markFailed(dir, out)
+				t.Failures = append(t.Failures, Failure{Package: dir, Output: out})
+			}
+			if !t.setup.KeepGoing {
+				return t.testError(err)
+			}
+			if first == nil {
+				first = t.testError(err)
+			}
+			// the profile of the failed run can't be split by package, so
+			// the packages that passed are run again for their coverage
+			var passed []string
+			for _, pkg := range results {
+				if pkg.Action == testjson.ActionPass {
+					passed = append(passed, pkg.Path)
+				}
+			}
+			if len(passed) == 0 {
+				continue
+			}
+			coverfiles = []string{coverfile + ".passed"}
+			if _, _, err := t.goTest(dir, passed, pkgs, coverfiles[0]); err != nil {
+				continue
+			}
+		}
+		if err := t.addCoverFiles(dir, coverfiles...); err != nil {
+			return err
+		}
+	}
+	return first
+}
+
+// retry reruns the failed tests of pkg with -run, up to setup.Retries times,
+// writing the coverage of each attempt next to coverfile. If an attempt
+// passes, pkg is updated with its results, and the profile of the attempt
+// and the tests that passed on it are returned. A package that failed
+// without a failed test, e.g. because it didn't build, is not retried.
+func (t *Tester) retry(dir string, targets, pkgs []string, pkg *testjson.Package, coverfile string) (string, []Flaky, bool) {
+	var names []string
+	for _, test := range pkg.Tests {
+		if (test.Action == testjson.ActionFail || test.Action == "") && !strings.Contains(test.Name, "/") {
+			names = append(names, regexp.QuoteMeta(test.Name))
+		}
+	}
+	if len(names) == 0 {
+		return "", nil, false
+	}
+	pattern := "^(" + strings.Join(names, "|") + ")$"
+	for attempt := 1; attempt <= t.setup.Retries; attempt++ {
+		retryfile := fmt.Sprintf("%s.retry%d", coverfile, attempt)
+		_, results, err := t.goTest(dir, targets, pkgs, retryfile, "-run", pattern)
+		if err != nil {
+			continue
+		}
+		passed := map[string]*testjson.Test{}
+		for _, r := range results {
+			for _, test := range r.Tests {
+				passed[test.Name] = test
+			}
+		}
+		var flaky []Flaky
+		for _, test := range pkg.Tests {
+			r, ok := passed[test.Name]
+			if !ok || test.Action == testjson.ActionPass {
+				continue
+			}
+			if !strings.Contains(test.Name, "/") {
+				flaky = append(flaky, Flaky{Package: pkg.Path, Test: test.Name, Attempts: attempt + 1})
+			}
+			test.Action = r.Action
+			test.Elapsed = r.Elapsed
+		}
+		pkg.Action = testjson.ActionPass
+		return retryfile, flaky, true
+	}
+	return "", nil, false
+}
+
+// written returns the coverfiles that exist, as a failed 'go test' run may
+// not write its profile
+func written(coverfiles ...string) []string {
+	var out []string
+	for _, coverfile := range coverfiles {
+		if _, err := os.Stat(coverfile); err == nil {
+			out = append(out, coverfile)
+		}
+	}
+	return out
+}
+
+// goTest runs 'go test' in dir for the targets, or for the package in dir if
+// there are none, with the coverage of pkgs written to coverfile and extra
+// arguments after the user's. It returns the combined output and the test
+// results.
+func (t *Tester) goTest(dir string, targets, pkgs []string, coverfile string, extra ...string) (string, []*testjson.Package, error) {
+	// parallel runs are buffered and written in one go when they are done, so
+	// that their output isn't interleaved
+	stream := t.setup.Verbose && t.setup.Parallel <= 1
+	combined, stdout, _ := logger.Log(
+		stream,
 		t.setup.Env.Stdout(),
 		t.setup.Env.Stderr(),
 	)
 	var args []string
-	var pkgs []string
-	for _, s := range t.setup.Packages {
-		pkgs = append(pkgs, s.Path)
-	}
-	args = append(args, "test")
+	args = append(args, "test", "-json")
 	if t.setup.Short {
 		// notest
 		// TODO: add test
@@ -306,35 +862,92 @@ func (t *Tester) processDir(dir string) error {
 		// notest
 		args = append(args, t.setup.TestArgs...)
 	}
-	if t.setup.Verbose {
-		fmt.Fprintf(
-			t.setup.Env.Stdout(),
-			"Running test: %s\n",
-			strings.Join(append([]string{"go"}, args...), " "),
-		)
+	args = append(args, extra...)
+	args = append(args, targets...)
+	header := fmt.Sprintf("Running test: %s\n", strings.Join(append([]string{"go"}, args...), " "))
+	if stream {
+		fmt.Fprint(t.setup.Env.Stdout(), header)
 	}
 
+	// stderr goes through the collector too: it passes lines that are not
+	// events through unchanged, and a single writer means a single pipe, so
+	// build errors and test output can't race each other into the buffer.
+	tests := testjson.New(stdout)
+	if t.progress != nil {
+		tests.SetProgress(t.progress)
+	}
 	exe := exec.Command("go", args...)
 	exe.Dir = dir
 	exe.Env = t.setup.Env.Environ()
-	exe.Stdout = nil
-	exe.Stderr = stderr
-	err = exe.Run()
-	if strings.Contains(combined.String(), "no buildable Go source files in") {
+	exe.Stdout = tests
+	exe.Stderr = tests
+	err := exe.Run()
+	if ferr := tests.Flush(); ferr != nil {
 		// notest
-		return nil
+		return "", nil, errors.Wrap(ferr, "Error writing test output")
+	}
+	if t.setup.Verbose && !stream {
+		t.outm.Lock()
+		fmt.Fprint(t.setup.Env.Stdout(), header+combined.String())
+		t.outm.Unlock()
+	}
+	return combined.String(), tests.Packages(), err
+}
+
+// testError returns the error of a failed 'go test' run. The output is
+// shown with -v, or in the summary of the failed tests.
+func (t *Tester) testError(err error) error {
+	return &shared.TestError{Err: errors.Wrap(err, "Error executing test")}
+}
+
+// addCoverFiles merges the profiles of the 'go test' runs in dir
+func (t *Tester) addCoverFiles(dir string, coverfiles ...string) error {
+	var profiles []*cover.Profile
+	for _, coverfile := range coverfiles {
+		p, err := cover.ParseProfiles(coverfile)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, p...)
+	}
+	if t.profiles == nil {
+		t.profiles = make(map[string][]*cover.Profile)
 	}
+	t.profiles[dir] = profiles
+	return t.addProfiles(profiles)
+}
 
+// singleSupported returns true if the go command writes a single profile
+// for several packages, which was added in go 1.20
+func (t *Tester) singleSupported() (bool, error) {
+	exe := exec.Command("go", "env", "GOVERSION")
+	exe.Env = t.setup.Env.Environ()
+	out, err := exe.Output()
 	if err != nil {
-		// TODO: Remove when https://github.com/dave/courtney/issues/4 is fixed
-		// notest
-		if t.setup.Verbose {
-			// They will already have seen the output
-			return errors.Wrap(err, "Error executing test")
+		return false, &shared.ToolError{Err: errors.Wrap(err, "Error getting go version")}
+	}
+	var minor int
+	// e.g. go1.21.4, or devel go1.22-abcdef
+	version := strings.TrimSpace(string(out))
+	if i := strings.Index(version, "go1."); i >= 0 {
+		fmt.Sscanf(version[i+len("go1."):], "%d", &minor)
+	}
+	return minor >= 20, nil
+}
+
+// markFailed makes sure that a package that failed is recorded as failed in
+// Tests. Older versions of go report build failures on stderr only.
+func (t *Tester) markFailed(ppath, output string) {
+	for _, pkg := range t.Tests {
+		if pkg.Path == ppath {
+			if pkg.Action != testjson.ActionFail {
+				pkg.Action = testjson.ActionFail
+				pkg.Output += output
+			}
+			return
 		}
-		return errors.Wrapf(err, "Error executing test \nOutput:[\n%s]\n", combined.String())
 	}
-	return t.processCoverageFile(coverfile)
+	t.Tests = append(t.Tests, &testjson.Package{Path: ppath, Action: testjson.ActionFail, Output: output})
 }
 
 func (t *Tester) processCoverageFile(filename string) error {
@@ -342,39 +955,19 @@ func (t *Tester) processCoverageFile(filename string) error {
 	if err != nil {
 		return err
 	}
+	return t.addProfiles(profiles)
+}
+
+// addProfiles merges copies of the profiles into Results, so the originals
+// are left untouched by later merges.
+func (t *Tester) addProfiles(profiles []*cover.Profile) error {
+	var err error
 	for _, p := range profiles {
-		if t.Results, err = merge.AddProfile(t.Results, p); err != nil {
+		cp := *p
+		cp.Blocks = append([]cover.ProfileBlock(nil), p.Blocks...)
+		if t.Results, err = merge.AddProfile(t.Results, &cp); err != nil {
 			return err
 		}
 	}
 	return nil
 }
-
-func undent(lines []string) []string {
-
-	indentRegex := regexp.MustCompile("[^\t]")
-	mindent := -1
-
-	for _, line := range lines {
-		loc := indentRegex.FindStringIndex(line)
-		if len(loc) == 0 {
-			// notest
-			// string is empty?
-			continue
-		}
-		if mindent == -1 || loc[0] < mindent {
-			mindent = loc[0]
-		}
-	}
-
-	var out []string
-	for _, line := range lines {
-		if line == "" {
-			// notest
-			out = append(out, "")
-		} else {
-			out = append(out, "\t"+line[mindent:])
-		}
-	}
-	return out
-}
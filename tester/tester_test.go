@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -16,6 +18,7 @@ import (
 	"github.com/heeus/gocov/shared/builder"
 	"github.com/heeus/gocov/shared/vos"
 	"github.com/heeus/gocov/tester"
+	"github.com/pkg/errors"
 	"golang.org/x/tools/cover"
 )
 
@@ -118,6 +121,9 @@ func TestTester_Enforce(t *testing.T) {
 			if err.Error() != expected {
 				t.Fatalf("Error enforcing - got \n%s\nexpected:\n%s\n", strconv.Quote(err.Error()), strconv.Quote(expected))
 			}
+			if code := shared.ExitCode(err); code != shared.ExitCoverage {
+				t.Fatalf("Error enforcing - got exit code %d, expected %d", code, shared.ExitCoverage)
+			}
 
 			// check that blocks next to each other are merged
 			ts.Results[0].Blocks = []cover.ProfileBlock{
@@ -377,4 +383,529 @@ func TestTester_Test(t *testing.T) {
 	}
 }
 
+func TestTester_Test_parallel(t *testing.T) {
+	env := vos.Mock()
+	sout := &bytes.Buffer{}
+	env.Setstdout(sout)
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	names := []string{"a", "b", "c", "d"}
+	for _, name := range names {
+		if _, _, err := b.Package(name, map[string]string{
+			name + ".go": "package " + name + "\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+			name + "_test.go": "package " + name + "\n\nimport \"testing\"\n\n" +
+				"func TestFoo(t *testing.T) {\n\tt.Log(\"in " + name + "\")\n\tFoo(1)\n}\n",
+		}); err != nil {
+			t.Fatalf("Error creating package %s: %+v", name, err)
+		}
+	}
+
+	setup := &shared.Setup{
+		Env:      env,
+		Paths:    shared.NewCache(env),
+		Verbose:  true,
+		Parallel: 3,
+	}
+	if err := setup.Parse([]string{"ns/..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	ts := tester.New(setup)
+	if err := ts.Test(); err != nil {
+		t.Fatalf("Error running tests: %+v", err)
+	}
+
+	if len(ts.Results) != len(names) {
+		t.Fatalf("Error in results - expected %d files, got %d", len(names), len(ts.Results))
+	}
+	for i, p := range ts.Results {
+		if p.FileName != path.Join("ns", names[i], names[i]+".go") || p.Blocks[0].Count == 0 {
+			t.Fatalf("Error in results - got %s %v", p.FileName, p.Blocks)
+		}
+	}
+	if len(ts.Tests) != len(names) {
+		t.Fatalf("Error in tests - expected %d packages, got %d", len(names), len(ts.Tests))
+	}
+	// the output of each package follows its own header
+	runs := strings.Split(sout.String(), "Running test: ")[1:]
+	if len(runs) != len(names) {
+		t.Fatalf("Error in output - expected %d runs:\n%s", len(names), sout.String())
+	}
+	for _, run := range runs {
+		var in []string
+		for _, name := range names {
+			if strings.Contains(run, "in "+name+"\n") {
+				in = append(in, name)
+			}
+		}
+		if len(in) != 1 {
+			t.Fatalf("Error in output - interleaved run:\n%s", run)
+		}
+	}
+}
+
+func TestTester_Test_single(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	if _, _, err := b.Package("a", map[string]string{
+		"a.go": "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	_, bdir, err := b.Package("b", map[string]string{
+		"b.go": "package b\n",
+		"b_test.go": "package b\n\nimport (\n\t\"testing\"\n\n\t\"ns/a\"\n)\n\n" +
+			"func TestFoo(t *testing.T) {\n\ta.Foo(1)\n}\n",
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if err := env.Setwd(filepath.Dir(bdir)); err != nil {
+		t.Fatalf("Error in Setwd: %+v", err)
+	}
+
+	setup := &shared.Setup{
+		Env:    env,
+		Paths:  shared.NewCache(env),
+		Single: true,
+	}
+	if err := setup.Parse([]string{"./..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	ts := tester.New(setup)
+	if err := ts.Test(); err != nil {
+		t.Fatalf("Error running tests: %+v", err)
+	}
+	// a has no tests, so it can only be covered by the tests of b
+	if len(ts.Results) != 1 || ts.Results[0].FileName != "ns/a/a.go" || ts.Results[0].Blocks[0].Count == 0 {
+		t.Fatalf("Error in results - got %#v", ts.Results)
+	}
+}
+
+func TestTester_Test_retries(t *testing.T) {
+	for _, single := range []bool{false, true} {
+		t.Run(fmt.Sprintf("single=%v", single), func(t *testing.T) {
+			env := vos.Mock()
+			b, err := builder.New(env, "ns", true)
+			if err != nil {
+				t.Fatalf("Error creating builder: %+v", err)
+			}
+			defer b.Cleanup()
+
+			// TestFlaky fails on the first run only, as it leaves a marker
+			// file, and only covers Foo when it passes
+			_, adir, err := b.Package("a", map[string]string{
+				"a.go": "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+				"a_test.go": "package a\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\n" +
+					"func TestStable(t *testing.T) {}\n\n" +
+					"func TestFlaky(t *testing.T) {\n" +
+					"\tif _, err := os.Stat(\"marker\"); err != nil {\n" +
+					"\t\tos.WriteFile(\"marker\", nil, 0644)\n\t\tt.Fatal(\"first run\")\n\t}\n\tFoo(1)\n}\n",
+			})
+			if err != nil {
+				t.Fatalf("Error creating package: %+v", err)
+			}
+			if err := env.Setwd(adir); err != nil {
+				t.Fatalf("Error in Setwd: %+v", err)
+			}
+
+			setup := &shared.Setup{
+				Env:     env,
+				Paths:   shared.NewCache(env),
+				Retries: 2,
+				Single:  single,
+			}
+			if err := setup.Parse([]string{"./..."}); err != nil {
+				t.Fatalf("Error parsing args: %+v", err)
+			}
+			ts := tester.New(setup)
+			if err := ts.Test(); err != nil {
+				t.Fatalf("Error running tests: %+v", err)
+			}
+			expected := []tester.Flaky{{Package: "ns/a", Test: "TestFlaky", Attempts: 2}}
+			if !reflect.DeepEqual(ts.Flaky, expected) {
+				t.Fatalf("Error in flaky tests - expected %#v, got %#v", expected, ts.Flaky)
+			}
+			if len(ts.Failures) != 0 {
+				t.Fatalf("Error in failures - got %#v", ts.Failures)
+			}
+			// the coverage of the passing attempt is merged
+			if got := counts(ts.Results); len(ts.Results) != 1 || got["ns/a/a.go"] == 0 {
+				t.Fatalf("Error in results - got %#v", got)
+			}
+		})
+	}
+}
+
+func TestTester_Test_retries_failed(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	if _, _, err := b.Package("a", map[string]string{
+		"a.go": "package a\n",
+		"a_test.go": "package a\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\n" +
+			"func TestFlaky(t *testing.T) {\n" +
+			"\tif _, err := os.Stat(\"marker\"); err != nil {\n" +
+			"\t\tos.WriteFile(\"marker\", nil, 0644)\n\t\tt.Fatal(\"first run\")\n\t}\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	_, bdir, err := b.Package("b", map[string]string{
+		"b.go":      "package b\n",
+		"b_test.go": "package b\n\nimport \"testing\"\n\nfunc TestBroken(t *testing.T) {\n\tt.Fatal(\"broken\")\n}\n",
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if err := env.Setwd(filepath.Dir(bdir)); err != nil {
+		t.Fatalf("Error in Setwd: %+v", err)
+	}
+	out := &bytes.Buffer{}
+	env.Setstdout(out)
+
+	setup := &shared.Setup{
+		Env:     env,
+		Paths:   shared.NewCache(env),
+		Retries: 1,
+		Single:  true,
+	}
+	if err := setup.Parse([]string{"./..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	ts := tester.New(setup)
+	if err := ts.Test(); err == nil {
+		t.Fatal("Error - expected the tests of ns/b to fail")
+	}
+	// the run failed, so no test is reported as flaky
+	if len(ts.Flaky) != 0 {
+		t.Fatalf("Error in flaky tests - got %#v", ts.Flaky)
+	}
+	for _, expected := range []string{"The following tests failed", "--- FAIL: ns/b TestBroken"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("Error in summary - expected to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestTester_Retest_cycles(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	// TestFlaky fails on the first run only, TestFile fails while the file
+	// "fail" exists
+	if _, _, err := b.Package("a", map[string]string{
+		"a.go": "package a\n",
+		"a_test.go": "package a\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\n" +
+			"func TestFlaky(t *testing.T) {\n" +
+			"\tif _, err := os.Stat(\"marker\"); err != nil {\n" +
+			"\t\tos.WriteFile(\"marker\", nil, 0644)\n\t\tt.Fatal(\"first run\")\n\t}\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	_, bdir, err := b.Package("b", map[string]string{
+		"b.go": "package b\n",
+		"b_test.go": "package b\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\n" +
+			"func TestFile(t *testing.T) {\n" +
+			"\tif _, err := os.Stat(\"fail\"); err == nil {\n\t\tt.Fatal(\"fail\")\n\t}\n}\n",
+		"fail": "",
+	})
+	if err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if err := env.Setwd(filepath.Dir(bdir)); err != nil {
+		t.Fatalf("Error in Setwd: %+v", err)
+	}
+	env.Setstdout(&bytes.Buffer{})
+
+	setup := &shared.Setup{
+		Env:     env,
+		Paths:   shared.NewCache(env),
+		Retries: 1,
+	}
+	if err := setup.Parse([]string{"./..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	ts := tester.New(setup)
+	if err := ts.Retest(setup.Packages, nil); err == nil {
+		t.Fatal("Error - expected the tests of ns/b to fail")
+	}
+	if len(ts.Flaky) != 1 || len(ts.Failures) != 1 {
+		t.Fatalf("Error in first cycle - got flaky %#v, failures %#v", ts.Flaky, ts.Failures)
+	}
+
+	// nothing fails in the second cycle, so nothing is left of the first
+	if err := os.Remove(filepath.Join(bdir, "fail")); err != nil {
+		t.Fatalf("Error removing file: %+v", err)
+	}
+	if err := ts.Retest(setup.Packages, nil); err != nil {
+		t.Fatalf("Error running tests: %+v", err)
+	}
+	if len(ts.Flaky) != 0 || len(ts.Failures) != 0 {
+		t.Fatalf("Error in second cycle - got flaky %#v, failures %#v", ts.Flaky, ts.Failures)
+	}
+}
+
+func TestTester_Test_keep_going(t *testing.T) {
+	for _, single := range []bool{false, true} {
+		t.Run(fmt.Sprintf("single=%v", single), func(t *testing.T) {
+			env := vos.Mock()
+			b, err := builder.New(env, "ns", true)
+			if err != nil {
+				t.Fatalf("Error creating builder: %+v", err)
+			}
+			defer b.Cleanup()
+
+			if _, _, err := b.Package("a", map[string]string{
+				"a.go":      "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+				"a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestFoo(t *testing.T) {\n\tt.Fatal(\"broken\")\n}\n",
+			}); err != nil {
+				t.Fatalf("Error creating package: %+v", err)
+			}
+			_, bdir, err := b.Package("b", map[string]string{
+				"b.go":      "package b\n\nfunc Bar(i int) int {\n\treturn i + 2\n}\n",
+				"b_test.go": "package b\n\nimport \"testing\"\n\nfunc TestBar(t *testing.T) {\n\tBar(1)\n}\n",
+			})
+			if err != nil {
+				t.Fatalf("Error creating package: %+v", err)
+			}
+			if err := env.Setwd(filepath.Dir(bdir)); err != nil {
+				t.Fatalf("Error in Setwd: %+v", err)
+			}
+			out := &bytes.Buffer{}
+			env.Setstdout(out)
+
+			setup := &shared.Setup{
+				Env:       env,
+				Paths:     shared.NewCache(env),
+				Single:    single,
+				KeepGoing: true,
+			}
+			if err := setup.Parse([]string{"./..."}); err != nil {
+				t.Fatalf("Error parsing args: %+v", err)
+			}
+			ts := tester.New(setup)
+			err = ts.Test()
+			var testErr *shared.TestError
+			if !errors.As(err, &testErr) || !strings.Contains(err.Error(), "1 of 2 packages: ns/a") {
+				t.Fatalf("Error - expected a test error for ns/a, got %+v", err)
+			}
+			if len(ts.Failures) != 1 || ts.Failures[0].Package != "ns/a" {
+				t.Fatalf("Error in failures - got %#v", ts.Failures)
+			}
+			// only the coverage of the package that passed is merged
+			got := counts(ts.Results)
+			if got["ns/a/a.go"] != 0 || got["ns/b/b.go"] == 0 {
+				t.Fatalf("Error in results - got %#v", got)
+			}
+			for _, expected := range []string{"The following tests failed", "--- FAIL: ns/a TestFoo"} {
+				if !strings.Contains(out.String(), expected) {
+					t.Fatalf("Error in summary - expected to contain %q, got:\n%s", expected, out.String())
+				}
+			}
+		})
+	}
+}
+
+// counts returns the sum of the block counts of each file
+func counts(results []*cover.Profile) map[string]int {
+	counts := map[string]int{}
+	for _, p := range results {
+		for _, b := range p.Blocks {
+			counts[p.FileName] += b.Count
+		}
+	}
+	return counts
+}
+
+func TestTester_Integration(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the script needs a shell")
+	}
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	if _, _, err := b.Package("a", map[string]string{
+		"a.go": "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if _, _, err := b.Package("cmd", map[string]string{
+		"main.go": "package main\n\nimport \"ns/a\"\n\nfunc main() {\n\ta.Foo(1)\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if err := b.File("", "e2e.sh", "#!/bin/sh\nset -e\ncmd\n"); err != nil {
+		t.Fatalf("Error creating script: %+v", err)
+	}
+	script := filepath.Join(b.Root(), "e2e.sh")
+	if err := env.Setwd(b.Root()); err != nil {
+		t.Fatalf("Error in Setwd: %+v", err)
+	}
+
+	setup := &shared.Setup{
+		Env:    env,
+		Paths:  shared.NewCache(env),
+		Builds: []string{"./cmd"},
+		Script: script,
+	}
+	if err := setup.Parse([]string{"./..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	ts := tester.New(setup)
+	if err := ts.Integration(); err != nil {
+		t.Fatalf("Error running integration: %+v", err)
+	}
+	counts := map[string]int{}
+	for _, p := range ts.Results {
+		for _, b := range p.Blocks {
+			counts[p.FileName] += b.Count
+		}
+	}
+	if counts["ns/a/a.go"] == 0 || counts["ns/cmd/main.go"] == 0 {
+		t.Fatalf("Error in results - got %v", counts)
+	}
+}
+
+func TestTester_Integration_errors(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	if _, _, err := b.Package("cmd", map[string]string{
+		"main.go": "package main\n\nfunc main() {\n\tundefined()\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if _, _, err := b.Package("tools/cmd", map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if err := env.Setwd(b.Root()); err != nil {
+		t.Fatalf("Error in Setwd: %+v", err)
+	}
+
+	setup := &shared.Setup{
+		Env:    env,
+		Paths:  shared.NewCache(env),
+		Builds: []string{"./cmd"},
+		Script: "true",
+	}
+	if err := setup.Parse([]string{"./..."}); err != nil {
+		t.Fatalf("Error parsing args: %+v", err)
+	}
+	// a package that doesn't build is not a test failure
+	err = tester.New(setup).Integration()
+	if shared.ExitCode(err) != shared.ExitTool || !strings.Contains(err.Error(), "Error building ./cmd") {
+		t.Fatalf("Error expected building ./cmd - got %v", err)
+	}
+
+	// the binaries would overwrite each other
+	setup.Builds = []string{"./cmd", "ns/tools/cmd"}
+	err = tester.New(setup).Integration()
+	if shared.ExitCode(err) != shared.ExitUsage || !strings.Contains(err.Error(), "would both be built as cmd") {
+		t.Fatalf("Error expected for packages with the same name - got %v", err)
+	}
+}
+
+func TestTester_Load_covdata(t *testing.T) {
+	env := vos.Mock()
+	b, err := builder.New(env, "ns", true)
+	if err != nil {
+		t.Fatalf("Error creating builder: %+v", err)
+	}
+	defer b.Cleanup()
+
+	if _, _, err := b.Package("a", map[string]string{
+		"a.go": "package a\n\nfunc Foo(i int) int {\n\treturn i + 1\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if _, _, err := b.Package("cmd", map[string]string{
+		"main.go": "package main\n\nimport \"ns/a\"\n\nfunc main() {\n\ta.Foo(1)\n}\n",
+	}); err != nil {
+		t.Fatalf("Error creating package: %+v", err)
+	}
+	if err := env.Setwd(b.Root()); err != nil {
+		t.Fatalf("Error in Setwd: %+v", err)
+	}
+
+	artifacts := filepath.Join(b.Root(), "artifacts")
+	covdir := filepath.Join(artifacts, "e2e")
+	if err := os.MkdirAll(covdir, 0777); err != nil {
+		t.Fatalf("Error creating dir: %+v", err)
+	}
+	bin := filepath.Join(b.Root(), "cmd.bin")
+	for _, args := range [][]string{
+		{"go", "build", "-cover", "-coverpkg=ns/...", "-o", bin, "./cmd"},
+		{bin},
+	} {
+		exe := exec.Command(args[0], args[1:]...)
+		exe.Dir = b.Root()
+		exe.Env = append(env.Environ(), "GOCOVERDIR="+covdir)
+		if out, err := exe.CombinedOutput(); err != nil {
+			t.Fatalf("Error running %v: %+v\n%s", args, err, out)
+		}
+	}
+	// a text profile next to the dir is loaded too
+	profile := "mode: set\nns/a/b.go:1.1,2.1 1 1\n"
+	if err := os.WriteFile(filepath.Join(artifacts, "unit.out"), []byte(profile), 0666); err != nil {
+		t.Fatalf("Error writing profile: %+v", err)
+	}
+
+	setup := &shared.Setup{
+		Env:   env,
+		Paths: shared.NewCache(env),
+		Load:  filepath.Join(artifacts, "*"),
+	}
+	ts := tester.New(setup)
+	if err := ts.Load(); err != nil {
+		t.Fatalf("Error loading: %+v", err)
+	}
+	if c := counts(ts.Results); c["ns/a/a.go"] == 0 || c["ns/cmd/main.go"] == 0 || c["ns/a/b.go"] != 1 {
+		t.Fatalf("Error in results - got %v", c)
+	}
+
+	setup.Load = b.Root()
+	if err := tester.New(setup).Load(); err == nil || !strings.Contains(err.Error(), "has no coverage data") {
+		t.Fatalf("Error expected for a dir without coverage data - got %v", err)
+	}
+
+	// data that 'go tool covdata' can't read is not a test failure
+	broken := filepath.Join(b.Root(), "broken")
+	if err := os.MkdirAll(broken, 0777); err != nil {
+		t.Fatalf("Error creating dir: %+v", err)
+	}
+	if err := os.WriteFile(filepath.Join(broken, "covmeta.0"), []byte("broken"), 0666); err != nil {
+		t.Fatalf("Error writing coverage data: %+v", err)
+	}
+	setup.Load = broken
+	if err := tester.New(setup).Load(); shared.ExitCode(err) != shared.ExitTool {
+		t.Fatalf("Error expected converting broken coverage data - got %v", err)
+	}
+}
+
 var annotatedLine = regexp.MustCompile(`// \d+$`)
@@ -0,0 +1,66 @@
+package testjson
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WriteFailures writes the failed tests of the packages with their output.
+// Tests that failed because a subtest failed are left out, and so are the
+// "=== RUN" lines. A package that failed without a failed test, e.g. because
+// it didn't build, is written with its own output.
+func WriteFailures(w io.Writer, packages []*Package) error {
+	b := bufio.NewWriter(w)
+	for _, pkg := range packages {
+		if pkg.Action != ActionFail {
+			continue
+		}
+		var failed []*Test
+		for _, test := range pkg.Tests {
+			if test.Action == ActionFail || test.Action == "" {
+				failed = append(failed, test)
+			}
+		}
+		if len(failed) == 0 {
+			fmt.Fprintf(b, "--- FAIL: %s\n", pkg.Path)
+			writeOutput(b, pkg.Output, "    ")
+			continue
+		}
+		for _, test := range failed {
+			if hasFailedSubtest(test, failed) {
+				continue
+			}
+			fmt.Fprintf(b, "--- FAIL: %s %s (%.2fs)\n", pkg.Path, test.Name, test.Elapsed)
+			writeOutput(b, test.Output, "")
+		}
+	}
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "Error writing failed tests")
+	}
+	return nil
+}
+
+func hasFailedSubtest(test *Test, failed []*Test) bool {
+	for _, other := range failed {
+		if strings.HasPrefix(other.Name, test.Name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOutput writes the lines of output with a prefix, without the lines
+// that go test adds around the output of each test
+func writeOutput(w io.Writer, output, prefix string) {
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "=== ") || strings.HasPrefix(trimmed, "--- FAIL: ") {
+			continue
+		}
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+	}
+}
@@ -0,0 +1,108 @@
+package testjson
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// DumpJUnit writes the results of the packages as a JUnit XML report with one
+// test suite per package. A package that failed without a failing test, e.g.
+// because it did not build, gets a failed TestMain test case holding the
+// package output.
+func DumpJUnit(packages []*Package, out io.Writer) error {
+	var suites junitTestSuites
+	var elapsed float64
+	for _, pkg := range packages {
+		suite := junitTestSuite{
+			Name:      pkg.Path,
+			Time:      seconds(pkg.Elapsed),
+			SystemOut: pkg.Output,
+		}
+		for _, test := range pkg.Tests {
+			tc := junitTestCase{
+				Classname: pkg.Path,
+				Name:      test.Name,
+				Time:      seconds(test.Elapsed),
+			}
+			switch test.Action {
+			case ActionFail:
+				tc.Failure = &junitMessage{Message: "Failed", Output: test.Output}
+				suite.Failures++
+			case ActionSkip:
+				tc.Skipped = &junitMessage{Message: "Skipped", Output: test.Output}
+				suite.Skipped++
+			case "":
+				// the test did not finish, e.g. because of a panic or timeout
+				tc.Failure = &junitMessage{Message: "Did not complete", Output: test.Output}
+				suite.Failures++
+			}
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+		if pkg.Action == ActionFail && suite.Failures == 0 {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Classname: pkg.Path,
+				Name:      "TestMain",
+				Time:      seconds(pkg.Elapsed),
+				Failure:   &junitMessage{Message: "Package failed", Output: pkg.Output},
+			})
+			suite.Failures++
+			suite.SystemOut = ""
+		}
+		suite.Tests = len(suite.TestCases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		elapsed += pkg.Elapsed
+		suites.Suites = append(suites.Suites, suite)
+	}
+	suites.Time = seconds(elapsed)
+
+	fmt.Fprint(out, xml.Header)
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "\t")
+	if err := enc.Encode(suites); err != nil {
+		return errors.Wrap(err, "Error writing JUnit report")
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+func seconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
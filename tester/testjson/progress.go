@@ -0,0 +1,60 @@
+package testjson
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Progress counts the results of one or more collectors, so that they can be
+// shown on a live progress line. It is safe for concurrent use.
+type Progress struct {
+	mu       sync.Mutex
+	start    time.Time
+	packages int
+	done     map[string]bool
+	passed   int
+	failed   int
+	skipped  int
+}
+
+// NewProgress returns a Progress for the number of packages provided,
+// started at start
+func NewProgress(packages int, start time.Time) *Progress {
+	return &Progress{packages: packages, start: start, done: map[string]bool{}}
+}
+
+// Add counts the result of a package or test. A package that is run again
+// is only counted once.
+func (p *Progress) Add(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch e.Action {
+	case ActionPass, ActionFail, ActionSkip:
+	default:
+		return
+	}
+	if e.Test == "" {
+		if e.Package != "" {
+			p.done[e.Package] = true
+		}
+		return
+	}
+	switch e.Action {
+	case ActionPass:
+		p.passed++
+	case ActionFail:
+		p.failed++
+	case ActionSkip:
+		p.skipped++
+	}
+}
+
+// Line returns the progress at the time provided, e.g.
+// "packages 3/10, tests 42 passed, 1 failed, 2 skipped, 12.3s"
+func (p *Progress) Line(now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("packages %d/%d, tests %d passed, %d failed, %d skipped, %.1fs",
+		len(p.done), p.packages, p.passed, p.failed, p.skipped, now.Sub(p.start).Seconds())
+}
@@ -0,0 +1,188 @@
+package testjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// Actions of test events
+const (
+	ActionRun    = "run"
+	ActionOutput = "output"
+	ActionPass   = "pass"
+	ActionFail   = "fail"
+	ActionSkip   = "skip"
+
+	ActionBuildOutput = "build-output"
+)
+
+// Event is a single event of 'go test -json', as described by
+// 'go doc test2json'
+type Event struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+
+	// build events of go 1.24 and later
+	ImportPath  string
+	FailedBuild string
+}
+
+// Package holds the results of the tests of a single package
+type Package struct {
+	Path    string
+	Action  string  // pass, fail or skip, empty while the package is running
+	Elapsed float64 // seconds
+	Output  string  // output that does not belong to a test
+	Tests   []*Test
+}
+
+// Text returns the output of the tests of the package followed by the output
+// of the package itself
+func (p *Package) Text() string {
+	var b strings.Builder
+	for _, test := range p.Tests {
+		b.WriteString(test.Output)
+	}
+	b.WriteString(p.Output)
+	return b.String()
+}
+
+// Test holds the result of a single test or subtest
+type Test struct {
+	Name    string
+	Action  string // pass, fail or skip, empty while the test is running
+	Elapsed float64
+	Output  string
+}
+
+// Collector is a writer that decodes the output of 'go test -json'. The text
+// output of the tests is written to out, so it reads the same as the output
+// of 'go test'. Lines that are not JSON events are written unchanged.
+type Collector struct {
+	out      io.Writer
+	buf      []byte
+	packages []*Package
+	tests    map[[2]string]*Test
+	builds   map[string]string
+	progress *Progress
+}
+
+// New returns a Collector that writes test output to out
+func New(out io.Writer) *Collector {
+	return &Collector{
+		out:    out,
+		tests:  map[[2]string]*Test{},
+		builds: map[string]string{},
+	}
+}
+
+// SetProgress makes the collector count the results in p too
+func (c *Collector) SetProgress(p *Progress) {
+	c.progress = p
+}
+
+// Write decodes the complete lines in p and keeps the rest for the next call
+func (c *Collector) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := c.buf[:i+1]
+		c.buf = c.buf[i+1:]
+		if err := c.line(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush decodes the last line if it isn't terminated by a newline
+func (c *Collector) Flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	line := c.buf
+	c.buf = nil
+	return c.line(line)
+}
+
+// Packages returns the packages seen so far in the order they started
+func (c *Collector) Packages() []*Package {
+	return c.packages
+}
+
+func (c *Collector) line(line []byte) error {
+	var e Event
+	if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &e) != nil || e.Action == "" {
+		_, err := c.out.Write(line)
+		return err
+	}
+	c.Add(e)
+	if e.Action == ActionOutput || e.Action == ActionBuildOutput {
+		_, err := io.WriteString(c.out, e.Output)
+		return err
+	}
+	return nil
+}
+
+// Add records a single event
+func (c *Collector) Add(e Event) {
+	if c.progress != nil {
+		c.progress.Add(e)
+	}
+	if e.Action == ActionBuildOutput {
+		c.builds[e.ImportPath] += e.Output
+		return
+	}
+	if e.Package == "" {
+		return
+	}
+	pkg := c.pkg(e.Package)
+	if e.Test == "" {
+		switch e.Action {
+		case ActionOutput:
+			pkg.Output += e.Output
+		case ActionPass, ActionFail, ActionSkip:
+			pkg.Action = e.Action
+			pkg.Elapsed = e.Elapsed
+			if e.FailedBuild != "" {
+				// the compiler errors come before the package result
+				pkg.Output = c.builds[e.FailedBuild] + pkg.Output
+			}
+		}
+		return
+	}
+	key := [2]string{e.Package, e.Test}
+	test, ok := c.tests[key]
+	if !ok {
+		test = &Test{Name: e.Test}
+		c.tests[key] = test
+		pkg.Tests = append(pkg.Tests, test)
+	}
+	switch e.Action {
+	case ActionOutput:
+		test.Output += e.Output
+	case ActionPass, ActionFail, ActionSkip:
+		test.Action = e.Action
+		test.Elapsed = e.Elapsed
+	}
+}
+
+func (c *Collector) pkg(path string) *Package {
+	for _, p := range c.packages {
+		if p.Path == path {
+			return p
+		}
+	}
+	p := &Package{Path: path}
+	c.packages = append(c.packages, p)
+	return p
+}
@@ -0,0 +1,148 @@
+package testjson_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heeus/gocov/tester/testjson"
+)
+
+const stream = `{"Action":"start","Package":"ns/a"}
+{"Action":"run","Package":"ns/a","Test":"TestA"}
+{"Action":"output","Package":"ns/a","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"output","Package":"ns/a","Test":"TestA","Output":"    a_test.go:9: want 1 & got 2\n"}
+{"Action":"output","Package":"ns/a","Test":"TestA","Output":"--- FAIL: TestA (0.01s)\n"}
+{"Action":"fail","Package":"ns/a","Test":"TestA","Elapsed":0.01}
+{"Action":"run","Package":"ns/a","Test":"TestB"}
+{"Action":"output","Package":"ns/a","Test":"TestB","Output":"--- SKIP: TestB (0.00s)\n"}
+{"Action":"skip","Package":"ns/a","Test":"TestB","Elapsed":0}
+{"Action":"run","Package":"ns/a","Test":"TestC"}
+{"Action":"pass","Package":"ns/a","Test":"TestC","Elapsed":0.25}
+{"Action":"output","Package":"ns/a","Output":"FAIL\n"}
+{"Action":"fail","Package":"ns/a","Elapsed":0.3}
+not json
+{"Action":"output","Package":"ns/b","Output":"ok  \tns/b\n"}
+{"Action":"pass","Package":"ns/b","Elapsed":0.1}
+{"ImportPath":"ns/c [ns/c.test]","Action":"build-output","Output":"./c.go:3:1: undefined: x\n"}
+{"ImportPath":"ns/c [ns/c.test]","Action":"build-fail"}
+{"Action":"output","Package":"ns/c","Output":"FAIL\tns/c [build failed]\n"}
+{"Action":"fail","Package":"ns/c","Elapsed":0,"FailedBuild":"ns/c [ns/c.test]"}`
+
+func collect(t *testing.T) ([]*testjson.Package, string) {
+	out := &bytes.Buffer{}
+	c := testjson.New(out)
+	// write in small chunks, so that lines are split between writes
+	for s := stream; len(s) > 0; {
+		n := 7
+		if n > len(s) {
+			n = len(s)
+		}
+		if _, err := c.Write([]byte(s[:n])); err != nil {
+			t.Fatalf("Error writing: %+v", err)
+		}
+		s = s[n:]
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Error flushing: %+v", err)
+	}
+	return c.Packages(), out.String()
+}
+
+func TestCollector(t *testing.T) {
+	pkgs, out := collect(t)
+	expectedOut := "=== RUN   TestA\n    a_test.go:9: want 1 & got 2\n--- FAIL: TestA (0.01s)\n--- SKIP: TestB (0.00s)\nFAIL\nnot json\nok  \tns/b\n./c.go:3:1: undefined: x\nFAIL\tns/c [build failed]\n"
+	if out != expectedOut {
+		t.Fatalf("Error in output - got:\n%q\nexpected:\n%q", out, expectedOut)
+	}
+	if len(pkgs) != 3 {
+		t.Fatalf("Error in packages - expected 3, got %d", len(pkgs))
+	}
+	a := pkgs[0]
+	if a.Path != "ns/a" || a.Action != testjson.ActionFail || a.Elapsed != 0.3 || a.Output != "FAIL\n" {
+		t.Fatalf("Error in package - got %#v", a)
+	}
+	var got []string
+	for _, test := range a.Tests {
+		got = append(got, test.Name+" "+test.Action)
+	}
+	if strings.Join(got, ",") != "TestA fail,TestB skip,TestC pass" {
+		t.Fatalf("Error in tests - got %v", got)
+	}
+	if a.Text() != "=== RUN   TestA\n    a_test.go:9: want 1 & got 2\n--- FAIL: TestA (0.01s)\n--- SKIP: TestB (0.00s)\nFAIL\n" {
+		t.Fatalf("Error in text - got %q", a.Text())
+	}
+	if a.Tests[2].Elapsed != 0.25 {
+		t.Fatalf("Error in elapsed - got %v", a.Tests[2].Elapsed)
+	}
+	if pkgs[1].Action != testjson.ActionPass || len(pkgs[1].Tests) != 0 {
+		t.Fatalf("Error in package - got %#v", pkgs[1])
+	}
+	if pkgs[2].Action != testjson.ActionFail || pkgs[2].Output != "./c.go:3:1: undefined: x\nFAIL\tns/c [build failed]\n" {
+		t.Fatalf("Error in package - got %#v", pkgs[2])
+	}
+}
+
+func TestDumpJUnit(t *testing.T) {
+	pkgs, _ := collect(t)
+	out := &bytes.Buffer{}
+	if err := testjson.DumpJUnit(pkgs, out); err != nil {
+		t.Fatalf("Error writing JUnit: %+v", err)
+	}
+	for _, expected := range []string{
+		`<testsuites tests="4" failures="2" skipped="1" time="0.400">`,
+		`<testsuite name="ns/a" tests="3" failures="1" skipped="1" time="0.300">`,
+		`<testcase classname="ns/a" name="TestA" time="0.010">`,
+		`<failure message="Failed">=== RUN   TestA&#xA;    a_test.go:9: want 1 &amp; got 2&#xA;`,
+		`<skipped message="Skipped">--- SKIP: TestB (0.00s)&#xA;</skipped>`,
+		`<testcase classname="ns/a" name="TestC" time="0.250"></testcase>`,
+		`<testsuite name="ns/b" tests="0" failures="0" skipped="0" time="0.100">`,
+		`<testcase classname="ns/c" name="TestMain" time="0.000">`,
+		`<failure message="Package failed">./c.go:3:1: undefined: x&#xA;FAIL&#x9;ns/c [build failed]&#xA;</failure>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("Error in JUnit - expected to contain %q:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := testjson.NewProgress(4, start)
+	c := testjson.New(&bytes.Buffer{})
+	c.SetProgress(p)
+	if _, err := c.Write([]byte(stream)); err != nil {
+		t.Fatalf("Error writing: %+v", err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Error flushing: %+v", err)
+	}
+	expected := "packages 3/4, tests 1 passed, 1 failed, 1 skipped, 2.5s"
+	if got := p.Line(start.Add(2500 * time.Millisecond)); got != expected {
+		t.Fatalf("Error in progress - got %q, expected %q", got, expected)
+	}
+
+	// a package that is run again is counted once
+	p.Add(testjson.Event{Action: testjson.ActionPass, Package: "ns/a"})
+	expected = "packages 3/4, tests 1 passed, 1 failed, 1 skipped, 2.5s"
+	if got := p.Line(start.Add(2500 * time.Millisecond)); got != expected {
+		t.Fatalf("Error in progress - got %q, expected %q", got, expected)
+	}
+}
+
+func TestWriteFailures(t *testing.T) {
+	pkgs, _ := collect(t)
+	out := &bytes.Buffer{}
+	if err := testjson.WriteFailures(out, pkgs); err != nil {
+		t.Fatalf("Error writing failures: %+v", err)
+	}
+	expected := "--- FAIL: ns/a TestA (0.01s)\n" +
+		"    a_test.go:9: want 1 & got 2\n" +
+		"--- FAIL: ns/c\n" +
+		"    ./c.go:3:1: undefined: x\n" +
+		"    FAIL\tns/c [build failed]\n"
+	if out.String() != expected {
+		t.Fatalf("Error in failures - got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/heeus/gocov/scanner"
+	"github.com/heeus/gocov/shared"
+	"github.com/heeus/gocov/tester"
+	"github.com/heeus/gocov/watcher"
+)
+
+// Watch runs the tests once and then polls the source files of the packages.
+// After every change it reruns the tests of the changed packages and of the
+// packages that import them, and prints the uncovered lines again. The
+// loaded packages are kept in memory between runs. Packages created after
+// the start are not picked up. Nested modules and output formats other than
+// text are not supported. Watch only returns on error.
+func Watch(setup *shared.Setup, interval time.Duration) error {
+	wt, err := newWatch(setup)
+	if err != nil {
+		return err
+	}
+	if err := wt.start(); err != nil {
+		return err
+	}
+	for {
+		// notest
+		time.Sleep(interval)
+		if _, err := wt.cycle(); err != nil {
+			return err
+		}
+	}
+}
+
+// watch holds the state of 'gocov watch' between cycles
+type watch struct {
+	setup  *shared.Setup
+	s      *scanner.CodeMap
+	t      *tester.Tester
+	w      *watcher.Watcher
+	byDir  map[string]shared.PackageSpec
+	byPath map[string]shared.PackageSpec
+}
+
+// newWatch scans the packages and takes the first snapshot of their files
+func newWatch(setup *shared.Setup) (*watch, error) {
+	if setup.RecurseModules {
+		return nil, &shared.UsageError{Err: errors.New("Error - -recurse-modules is not supported by watch")}
+	}
+	if !setup.TextOutput() {
+		return nil, &shared.UsageError{Err: errors.New("Error - -format is not supported by watch")}
+	}
+	if err := setup.Parse(setup.Args); err != nil {
+		return nil, errors.Wrapf(err, "Parse")
+	}
+
+	s := scanner.New(setup)
+	if err := s.LoadProgram(); err != nil {
+		return nil, errors.Wrapf(err, "LoadProgram")
+	}
+	if err := s.ScanPackages(); err != nil {
+		return nil, errors.Wrapf(err, "ScanPackages")
+	}
+
+	wt := &watch{
+		setup:  setup,
+		s:      s,
+		t:      tester.New(setup),
+		byDir:  map[string]shared.PackageSpec{},
+		byPath: map[string]shared.PackageSpec{},
+	}
+	var dirs []string
+	for _, spec := range setup.Packages {
+		wt.byDir[spec.Dir] = spec
+		wt.byPath[spec.Path] = spec
+		dirs = append(dirs, spec.Dir)
+	}
+	wt.w = watcher.New(dirs)
+	if _, err := wt.w.Changed(); err != nil {
+		return nil, errors.Wrapf(err, "Changed")
+	}
+	return wt, nil
+}
+
+// start runs the tests of all packages
+func (wt *watch) start() error {
+	return wt.print(wt.t.Retest(wt.setup.Packages, nil))
+}
+
+// cycle reruns the tests of the packages that changed since the previous
+// cycle and of their dependents. It returns the rerun packages.
+func (wt *watch) cycle() ([]shared.PackageSpec, error) {
+	changedDirs, err := wt.w.Changed()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Changed")
+	}
+	var changed []shared.PackageSpec
+	var paths []string
+	for _, dir := range changedDirs {
+		if spec, ok := wt.byDir[dir]; ok {
+			changed = append(changed, spec)
+			paths = append(paths, spec.Path)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	// imports of the changed packages may have changed too
+	if err := wt.s.LoadGraph(); err != nil {
+		// notest
+		printWatchError(wt.setup, err)
+		return nil, nil
+	}
+	var affected []shared.PackageSpec
+	for _, ppath := range wt.s.Dependents(paths) {
+		if spec, ok := wt.byPath[ppath]; ok {
+			affected = append(affected, spec)
+		}
+	}
+	fmt.Fprintf(wt.setup.Env.Stdout(), "\nChanged: %s. Running tests in %d package(s)\n", strings.Join(paths, ", "), len(affected))
+
+	if err := wt.s.Rescan(changed); err != nil {
+		// notest
+		printWatchError(wt.setup, err)
+		return nil, nil
+	}
+	return affected, wt.print(wt.t.Retest(affected, paths))
+}
+
+// print prints the result of a test run and the uncovered lines
+func (wt *watch) print(testErr error) error {
+	if testErr != nil {
+		printWatchError(wt.setup, testErr)
+	}
+	if err := wt.t.ProcessExcludes(wt.s.Excludes); err != nil {
+		// notest
+		return errors.Wrapf(err, "ProcessExcludes")
+	}
+	if err := wt.t.Save(); err != nil {
+		// notest
+		return errors.Wrapf(err, "Save")
+	}
+	out := tester.CoverageFileName
+	defer os.Remove(out)
+	return printCoverage(wt.setup, wt.s, wt.t)
+}
+
+// printWatchError prints an error that doesn't stop watching
+func printWatchError(setup *shared.Setup, err error) {
+	fmt.Fprintln(setup.Env.Stdout(), err.Error())
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// New creates a Watcher for the .go files in the provided dirs. The first
+// call to Changed takes the initial snapshot.
+func New(dirs []string) *Watcher {
+	return &Watcher{
+		dirs: dirs,
+	}
+}
+
+// Watcher polls directories for added, modified and removed .go files
+type Watcher struct {
+	dirs     []string
+	snapshot map[string]stamp
+}
+
+type stamp struct {
+	mod  time.Time
+	size int64
+}
+
+// Changed returns the dirs that contain .go files that were added, modified
+// or removed since the previous call.
+func (w *Watcher) Changed() ([]string, error) {
+	current := make(map[string]stamp)
+	for _, dir := range w.dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// notest
+				continue
+			}
+			return nil, errors.Wrapf(err, "Error reading files from %s", dir)
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				// notest
+				// removed since ReadDir
+				continue
+			}
+			current[filepath.Join(dir, e.Name())] = stamp{mod: info.ModTime(), size: info.Size()}
+		}
+	}
+
+	previous := w.snapshot
+	w.snapshot = current
+	if previous == nil {
+		return nil, nil
+	}
+
+	changed := map[string]bool{}
+	for fpath, s := range current {
+		// Equal ignores the monotonic clock reading and the location
+		if p, ok := previous[fpath]; !ok || !p.mod.Equal(s.mod) || p.size != s.size {
+			changed[filepath.Dir(fpath)] = true
+		}
+	}
+	for fpath := range previous {
+		if _, ok := current[fpath]; !ok {
+			changed[filepath.Dir(fpath)] = true
+		}
+	}
+	var dirs []string
+	for dir := range changed {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
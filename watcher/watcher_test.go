@@ -0,0 +1,58 @@
+package watcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/heeus/gocov/watcher"
+)
+
+func TestWatcher_Changed(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	for _, dir := range []string{a, b} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatalf("Error creating dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "x.go"), []byte("package x"), 0666); err != nil {
+			t.Fatalf("Error creating file: %s", err)
+		}
+	}
+
+	w := watcher.New([]string{a, b})
+	check := func(name string, expected []string) {
+		changed, err := w.Changed()
+		if err != nil {
+			t.Fatalf("Error in %s: %s", name, err)
+		}
+		if !reflect.DeepEqual(changed, expected) {
+			t.Fatalf("Error in %s - got %#v, expected %#v", name, changed, expected)
+		}
+	}
+
+	check("initial snapshot", nil)
+	check("no change", nil)
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(a, "x.go"), later, later); err != nil {
+		t.Fatalf("Error touching file: %s", err)
+	}
+	check("modified", []string{a})
+
+	if err := os.WriteFile(filepath.Join(b, "notes.txt"), []byte("x"), 0666); err != nil {
+		t.Fatalf("Error creating file: %s", err)
+	}
+	check("non-go file", nil)
+
+	if err := os.WriteFile(filepath.Join(b, "y_test.go"), []byte("package x"), 0666); err != nil {
+		t.Fatalf("Error creating file: %s", err)
+	}
+	if err := os.Remove(filepath.Join(a, "x.go")); err != nil {
+		t.Fatalf("Error removing file: %s", err)
+	}
+	check("added and removed", []string{a, b})
+}